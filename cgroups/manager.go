@@ -6,7 +6,10 @@ package cgroups
 
 import (
 	"docker-go/cgroups/subsystem"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"path"
+	"syscall"
 )
 
 type CGroupManager struct {
@@ -44,3 +47,28 @@ func (c *CGroupManager) Destroy() {
 
 	}
 }
+
+// Kill 向 cgroup 中的所有进程发送信号
+func (c *CGroupManager) Kill(sig syscall.Signal) error {
+	// 不能向根 cgroup 中的进程发送信号
+	if p := path.Clean("/" + c.Path); p == "/" {
+		return fmt.Errorf("refuse to kill processes in root cgroup")
+	}
+	pids := make(map[int]bool)
+	for _, subystem := range subsystem.Subsystems {
+		procs, err := subsystem.CgroupProcs(subystem.Name(), c.Path)
+		if err != nil {
+			return err
+		}
+		for _, pid := range procs {
+			pids[pid] = true
+		}
+	}
+	for pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+
+	return nil
+}
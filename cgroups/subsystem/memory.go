@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type MemorySubSystem struct {
@@ -26,9 +27,10 @@ func (m *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 		return err
 	}
 	if res.MemoryLimit != "" {
+		m.apply = true
 		// 设置cgroup内存限制
 		// 将这个限制写入到cgroup对应目录的 memory.limit_in_bytes 文件即可
-		err := ioutil.WriteFile(path.Join(subsystemCgroupPath, "memoey.limit_in_bytes"), []byte(res.MemoryLimit), 0644)
+		err := ioutil.WriteFile(path.Join(subsystemCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644)
 		if err != nil {
 			return err
 		}
@@ -66,3 +68,33 @@ func (m *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 
 	return nil
 }
+
+// OOMKilled 判断 cgroup 中是否有进程因为超出内存限制被杀死，cgroup 需要在容器退出后、删除之前检查
+// cgroup v1 读取 memory.oom_control，cgroup v2 读取 memory.events，其中的 oom_kill 为被杀死的进程数
+func OOMKilled(cgroupPath string) (bool, error) {
+	file := "memory.oom_control"
+	mountPoint, err := findCgroupMountPoint("memory")
+	if err == nil && mountPoint == "" {
+		file = "memory.events"
+		mountPoint, err = findCgroup2MountPoint()
+	}
+	if err != nil || mountPoint == "" {
+		return false, err
+	}
+	bs, err := ioutil.ReadFile(path.Join(mountPoint, cgroupPath, file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, err := strconv.Atoi(fields[1])
+			return n > 0, err
+		}
+	}
+
+	return false, nil
+}
@@ -2,9 +2,12 @@ package subsystem
 
 import (
 	"bufio"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -47,3 +50,98 @@ func findCgroupMountPoint(subsystem string) (string, error) {
 
 	return "", scanner.Err()
 }
+
+// 进程所在的 cgroup，cgroup v2 的 subsystem 为空
+type procCgroup struct {
+	subsystem string
+	path      string
+}
+
+// 解析 /proc/<pid>/cgroup 的内容，每行的格式为 4:memory:/docker-go 或 cgroup v2 的 0::/docker-go
+// 同时挂载多个 subsystem 的层级，如 cpu,cpuacct，取第一个 subsystem
+func parseProcCgroups(content string) []procCgroup {
+	var cgroups []procCgroup
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		cgroups = append(cgroups, procCgroup{subsystem: strings.Split(parts[1], ",")[0], path: parts[2]})
+	}
+
+	return cgroups
+}
+
+// JoinCgroupsOf 将进程 pid 加入进程 target 所在的全部 cgroup，exec 时用于让新进程受到容器的资源限制
+func JoinCgroupsOf(target, pid int) error {
+	bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", target))
+	if err != nil {
+		return err
+	}
+	for _, cgroup := range parseProcCgroups(string(bs)) {
+		var mountPoint string
+		if cgroup.subsystem == "" {
+			mountPoint, err = findCgroup2MountPoint()
+		} else {
+			mountPoint, err = findCgroupMountPoint(cgroup.subsystem)
+		}
+		if err != nil {
+			return err
+		}
+		if mountPoint == "" {
+			continue
+		}
+		procsPath := path.Join(mountPoint, cgroup.path, "cgroup.procs")
+		if err = ioutil.WriteFile(procsPath, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("join cgroup %s: %v", procsPath, err)
+		}
+	}
+
+	return nil
+}
+
+// 找到 cgroup v2 的挂载点
+func findCgroup2MountPoint() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		txt := scanner.Text()
+		i := strings.Index(txt, " - ")
+		fields := strings.Split(txt, " ")
+		if i >= 0 && strings.HasPrefix(txt[i+3:], "cgroup2 ") && len(fields) > 4 {
+			return fields[4], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// CgroupProcs 获取 subsystem 中 cgroupPath 下的所有进程，cgroup 不存在时返回空
+func CgroupProcs(subsystem, cgroupPath string) ([]int, error) {
+	mountPoint, err := findCgroupMountPoint(subsystem)
+	if err != nil || mountPoint == "" {
+		return nil, err
+	}
+	bs, err := ioutil.ReadFile(path.Join(mountPoint, cgroupPath, "cgroup.procs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(bs)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q in cgroup.procs", field)
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
@@ -2,6 +2,7 @@ package subsystem
 
 import (
 	"github.com/sirupsen/logrus"
+	"reflect"
 	"testing"
 )
 
@@ -10,3 +11,24 @@ func TestGetCgroupPath(t *testing.T) {
 	logrus.Infof(findCgroupMountPoint("cpu"))
 	logrus.Infof(findCgroupMountPoint("cpuset"))
 }
+
+func TestParseProcCgroups(t *testing.T) {
+	content := `12:memory:/docker-go/web
+5:cpu,cpuacct:/docker-go/web
+1:name=systemd:/user.slice
+0::/docker-go/web
+invalid line
+`
+	expected := []procCgroup{
+		{subsystem: "memory", path: "/docker-go/web"},
+		{subsystem: "cpu", path: "/docker-go/web"},
+		{subsystem: "name=systemd", path: "/user.slice"},
+		{subsystem: "", path: "/docker-go/web"},
+	}
+	if cgroups := parseProcCgroups(content); !reflect.DeepEqual(cgroups, expected) {
+		t.Errorf("parseProcCgroups = %+v, expected %+v", cgroups, expected)
+	}
+	if cgroups := parseProcCgroups("3:pids:/a:b\n"); len(cgroups) != 1 || cgroups[0].path != "/a:b" {
+		t.Errorf("parseProcCgroups with colon in path = %+v", cgroups)
+	}
+}
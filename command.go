@@ -6,12 +6,12 @@ package main
 
 import (
 	"docker-go/cgroups/subsystem"
-	"docker-go/common"
 	"docker-go/container"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"os"
+	"path"
+	"time"
 )
 
 // 创建namespace隔离的容器进程
@@ -36,13 +36,37 @@ var runCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "docker volume",
+			Usage: "docker volume, host:container[:ro|rw], host can be a volume name",
+		},
+		cli.StringFlag{
+			Name:  "volume-driver",
+			Usage: "driver for named volumes that do not exist yet",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs, container[:size=64m,mode=1777,noexec,ro]",
+		},
+		cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "attach a filesystem mount, only type=tmpfs is supported (e.g. type=tmpfs,destination=/run,tmpfs-size=64m)",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, host[:container][:rwm]",
+		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "storage driver options, size=<size> limits the container write layer (e.g. size=10G)",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only, volumes and tmpfs stay writable",
 		},
 		cli.BoolFlag{
 			Name:  "d",
-			Usage: "detach container",
+			Usage: "detach container, use attach to reconnect to it",
 		},
 		cli.StringFlag{
 			Name:  "name",
@@ -50,7 +74,31 @@ var runCommand = cli.Command{
 		},
 		cli.StringSliceFlag{
 			Name:  "e",
-			Usage: "docker env",
+			Usage: "set environment variables, KEY=VALUE or KEY to use the host value",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container, default /",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "username or uid, optionally with group, user[:group]",
+		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name, default container id",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "ulimit options, name=soft[:hard] (e.g. nofile=1024:2048)",
+		},
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Usage: "signal to stop the container, defaults to the image's stop signal or SIGTERM",
+		},
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
 		},
 		cli.StringFlag{
 			Name:  "net",
@@ -82,21 +130,68 @@ var runCommand = cli.Command{
 		tty := context.Bool("ti")
 		detach := context.Bool("d")
 
-		if tty && detach {
-			return fmt.Errorf("ti and d paramter can not both provided")
-		}
-
 		containerName := context.String("name")
-		volume := context.String("v")
+		volumes, err := container.ParseVolumes(context.StringSlice("v"))
+		if err != nil {
+			return err
+		}
+		for _, volume := range volumes {
+			if volume.Name != "" {
+				volume.Driver = context.String("volume-driver")
+			}
+		}
+		tmpfs, err := container.ParseTmpfsMounts(context.StringSlice("tmpfs"), context.StringSlice("mount"))
+		if err != nil {
+			return err
+		}
+		if err = container.CheckMountPoints(volumes, tmpfs); err != nil {
+			return err
+		}
+		devices, err := container.ParseDevices(context.StringSlice("device"))
+		if err != nil {
+			return err
+		}
+		storageSize, err := container.ParseStorageOpts(context.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
+		if workDir := context.String("w"); workDir != "" && !path.IsAbs(workDir) {
+			return fmt.Errorf("working directory %s is not absolute", workDir)
+		}
+		rlimits, err := container.ParseUlimits(context.StringSlice("ulimit"))
+		if err != nil {
+			return err
+		}
 		net := context.String("net")
 		// 要运行的镜像名
 		imageName := context.Args().Get(0)
-		envs := context.StringSlice("e")
+		stopSignal, err := container.ResolveStopSignal(imageName, context.String("stop-signal"))
+		if err != nil {
+			return err
+		}
 		ports := context.StringSlice("p")
+		// 只传入 -e 指定的环境变量，不继承宿主机的环境
+		env, err := container.ParseEnv(context.StringSlice("e"))
+		if err != nil {
+			return err
+		}
 
-		Run(cmdArray, tty, res, containerName, imageName, volume, net, envs, ports)
-
-		return nil
+		// 容器 init 进程的全部配置
+		config := &container.InitConfig{
+			Args:     cmdArray,
+			Env:      env,
+			Cwd:      context.String("w"),
+			User:     context.String("u"),
+			Hostname: context.String("hostname"),
+			Tty:      tty,
+			ReadOnly: context.Bool("read-only"),
+			Volumes:  volumes,
+			Tmpfs:    tmpfs,
+			Devices:  devices,
+			Rlimits:  rlimits,
+			Init:     context.Bool("init"),
+		}
+		return Run(config, detach, res, containerName, imageName, storageSize, stopSignal, net, ports)
 	},
 }
 
@@ -111,19 +206,29 @@ var initCommand = cli.Command{
 }
 
 // 导出容器内容
+// commit 镜像名: 将容器文件系统打包为 tar
+// commit 容器名 镜像名: 将容器的读写层作为新的一层，生成多层镜像
 var commitCommand = cli.Command{
-	Name:  "commit",
-	Usage: "docker commit a container into image",
+	Name:      "commit",
+	Usage:     "docker commit a container into image",
+	ArgsUsage: "[container] image",
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name:  "c",
 			Usage: "export image path",
 		},
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Usage: "signal to stop containers of the new image, defaults to the one of the container's image",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
+		if len(context.Args()) > 1 {
+			return container.CommitContainerLayer(context.Args().Get(0), context.Args().Get(1), context.String("stop-signal"))
+		}
 		imageName := context.Args().Get(0)
 		imagePath := context.String("c")
 		return container.CommitContainer(imageName, imagePath)
@@ -159,13 +264,13 @@ var logCommand = cli.Command{
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "ti",
+			Usage: "allocate a pseudo-TTY and keep stdin open",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		// 如果环境变量里面有 PID,那么则什么都不执行
-		pid := os.Getenv(common.EnvExecPid)
-		if pid != "" {
-			logrus.Infof("pid callback pid %s, gid: %d", pid, os.Getgid())
-			return nil
-		}
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("missing container name or command")
 		}
@@ -174,21 +279,167 @@ var execCommand = cli.Command{
 			cmdArray = append(cmdArray, arg)
 		}
 		containerName := context.Args().Get(0)
-		container.ExecContainer(containerName, cmdArray)
+		code, err := container.ExecContainer(containerName, cmdArray, context.Bool("ti"))
+		if err != nil {
+			return err
+		}
+		// 以命令的退出码退出
+		if code != 0 {
+			return cli.NewExitError("", code)
+		}
 		return nil
 	},
 }
 
+// 连接到后台容器的标准输入输出
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach to the stdio of a detached container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Value: container.DefaultDetachKeys,
+			Usage: "key sequence for detaching from the container, empty to disable",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.AttachContainer(context.Args().Get(0), context.String("detach-keys"))
+	},
+}
+
+// 后台容器的 shim，由 run 和 start 启动
+var shimCommand = cli.Command{
+	Name:  "shim",
+	Usage: "Monitor a detached container. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.RunShim(context.Args().Get(0))
+	},
+}
+
+// 查看容器详细信息
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information on a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.InspectContainer(context.Args().Get(0))
+	},
+}
+
 // 停止容器
 var stopCommand = cli.Command{
 	Name:  "stop",
-	Usage: "stop a container",
+	Usage: "stop one or more containers",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t, time",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing the container",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing stop container name")
 		}
-		containerName := context.Args().Get(0)
-		container.StopContainer(containerName)
+		timeout := time.Duration(context.Int("t")) * time.Second
+		for _, containerName := range context.Args() {
+			if err := container.StopContainer(containerName, timeout); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 向容器发送信号
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "send a signal to one or more containers",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s, signal",
+			Value: "SIGKILL",
+			Usage: "signal to send to the container",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing kill container name")
+		}
+		for _, containerName := range context.Args() {
+			if err := container.KillContainer(containerName, context.String("s")); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 启动已经停止的容器
+var startCommand = cli.Command{
+	Name:  "start",
+	Usage: "start one or more stopped containers",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing start container name")
+		}
+		for _, containerName := range context.Args() {
+			if err := Start(containerName); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 重启容器
+var restartCommand = cli.Command{
+	Name:  "restart",
+	Usage: "restart one or more containers",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t, time",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing the container",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing restart container name")
+		}
+		timeout := time.Duration(context.Int("t")) * time.Second
+		for _, containerName := range context.Args() {
+			if err := Restart(containerName, timeout); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 等待容器退出
+var waitCommand = cli.Command{
+	Name:  "wait",
+	Usage: "block until one or more containers stop, then print their exit codes",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing wait container name")
+		}
+		for _, containerName := range context.Args() {
+			code, err := container.WaitContainer(containerName)
+			if err != nil {
+				return err
+			}
+			fmt.Println(code)
+		}
 		return nil
 	},
 }
@@ -206,3 +457,307 @@ var removeCommand = cli.Command{
 		return nil
 	},
 }
+
+// 查看容器文件系统变更
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "inspect changes to files or directories on a container's filesystem",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "output in json format",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return container.DiffContainer(containerName, context.Bool("json"))
+	},
+}
+
+// 在宿主机与容器之间拷贝文件
+var copyCommand = cli.Command{
+	Name:      "cp",
+	Usage:     "copy files/folders between a container and the local filesystem",
+	ArgsUsage: "container:src_path dest_path | src_path container:dest_path",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing copy source or destination")
+		}
+		srcContainer, srcPath := container.ParseCopyPath(context.Args().Get(0))
+		dstContainer, dstPath := container.ParseCopyPath(context.Args().Get(1))
+		switch {
+		case srcContainer != "" && dstContainer == "":
+			return container.CopyFromContainer(srcContainer, srcPath, dstPath)
+		case srcContainer == "" && dstContainer != "":
+			return container.CopyToContainer(srcPath, dstContainer, dstPath)
+		default:
+			return fmt.Errorf("exactly one of source and destination must be a container path")
+		}
+	},
+}
+
+// 清理命令的通用参数
+var pruneFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only show what would be removed",
+	},
+	cli.StringSliceFlag{
+		Name:  "filter",
+		Usage: "provide filter values (e.g. 'until=24h')",
+	},
+}
+
+// 系统管理
+var systemCommand = cli.Command{
+	Name:  "system",
+	Usage: "manage docker-go",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove stopped containers, unused images, orphaned layers, mounts and state dirs",
+			Flags: pruneFlags,
+			Action: func(context *cli.Context) error {
+				opts, err := container.NewPruneOptions(context.Bool("dry-run"), context.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
+				return container.PruneSystem(opts)
+			},
+		},
+		{
+			Name:  "df",
+			Usage: "show docker-go disk usage",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "v, verbose",
+					Usage: "show detailed information on space usage",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "output in json format",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return container.SystemDiskUsage(context.Bool("verbose"), context.Bool("json"))
+			},
+		},
+	},
+}
+
+// 镜像管理
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "manage images",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove extracted image dirs not used by any container",
+			Flags: pruneFlags,
+			Action: func(context *cli.Context) error {
+				opts, err := container.NewPruneOptions(context.Bool("dry-run"), context.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
+				return container.PruneImages(opts)
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "show the layers of an image",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				return container.ImageHistory(context.Args().Get(0))
+			},
+		},
+		{
+			Name:  "squash",
+			Usage: "merge image layers into one and save as a new image",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "first layer to squash, digest prefix or index (default: all layers)",
+				},
+				cli.StringFlag{
+					Name:  "t",
+					Usage: "new image name (default: <image>-squashed)",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				return container.SquashImage(context.Args().Get(0), context.String("from"), context.String("t"))
+			},
+		},
+		{
+			Name:  "keygen",
+			Usage: "generate an ed25519 key pair for signing images",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "o",
+					Usage: "output file prefix, writes <prefix>.key and <prefix>.pub",
+					Value: "docker-go",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return container.GenerateSigningKey(context.String("o"))
+			},
+		},
+		{
+			Name:  "sign",
+			Usage: "sign an image manifest with a private key",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "private key file",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				if context.String("key") == "" {
+					return fmt.Errorf("missing private key")
+				}
+				return container.SignImage(context.Args().Get(0), context.String("key"))
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "verify an image against the trust policy",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				imageName := context.Args().Get(0)
+				if err := container.VerifyImage(imageName); err != nil {
+					return err
+				}
+				fmt.Printf("image %s verified\n", imageName)
+				return nil
+			},
+		},
+	},
+}
+
+// 容器管理
+var containerCommand = cli.Command{
+	Name:  "container",
+	Usage: "manage containers",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove all stopped containers",
+			Flags: pruneFlags,
+			Action: func(context *cli.Context) error {
+				opts, err := container.NewPruneOptions(context.Bool("dry-run"), context.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
+				return container.PruneContainers(opts)
+			},
+		},
+	},
+}
+
+// 数据卷管理
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "manage volumes",
+	Subcommands: []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "create a volume",
+			ArgsUsage: "[name]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "d, driver",
+					Usage: "volume driver name (default: local)",
+				},
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set metadata for a volume (e.g. 'key=value')",
+				},
+				cli.StringSliceFlag{
+					Name:  "o, opt",
+					Usage: "set driver specific options (e.g. 'key=value')",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return container.CreateVolume(context.Args().Get(0), context.String("driver"),
+					context.StringSlice("label"), context.StringSlice("opt"))
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "list volumes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "q, quiet",
+					Usage: "only display volume names",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return container.ListVolumes(context.Bool("quiet"))
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "display detailed information on one or more volumes",
+			ArgsUsage: "volume [volume...]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				return container.InspectVolumes(context.Args())
+			},
+		},
+		{
+			Name:      "rm",
+			Usage:     "remove one or more volumes not used by any container",
+			ArgsUsage: "volume [volume...]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				return container.RemoveVolumes(context.Args())
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove all volumes not used by any container",
+			Flags: pruneFlags,
+			Action: func(context *cli.Context) error {
+				opts, err := container.NewPruneOptions(context.Bool("dry-run"), context.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
+				return container.PruneVolumes(opts)
+			},
+		},
+		{
+			Name:      "serve-plugin",
+			Usage:     "run a directory backed volume driver plugin for testing",
+			ArgsUsage: "driver-name",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "directory to store the volumes in",
+					Value: "/var/lib/docker-go/plugin-volumes",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing driver name")
+				}
+				return container.ServeVolumePlugin(context.Args().Get(0), context.String("root"))
+			},
+		},
+	},
+}
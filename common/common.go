@@ -8,20 +8,40 @@ const (
 )
 
 const (
+	Created = "created"
 	Running = "running"
 	Stop    = "stopped"
 	Exit    = "exited"
 )
 
+// 容器的 cgroup 为 CgroupRootPath/容器名
+const CgroupRootPath = "docker-go"
+
 const (
 	DefaultContainerInfoPath = "/var/run/docker-go/"
 	ContainerInfoFileName    = "config.json"
 	ContainerLogFileName     = "container.log"
+	ShimLogFileName          = "shim.log"    // shim 进程自身的日志
+	AttachSocketName         = "attach.sock" // 后台容器的 shim 监听的 socket
+)
+
+// 镜像清单与签名
+const (
+	ImageStorePath         = "/root/images/"
+	ImageLayerPath         = "/root/imageLayers/"
+	ImageManifestFileName  = "manifest.json"
+	ImageSignatureDirName  = "signatures"
+	ImageRootfsDigestName  = "rootfs.digest" // /root/镜像名 解压自哪个摘要的内容
+	DefaultTrustPolicyPath = "/etc/docker-go/policy.json"
 )
 
+// 命名数据卷
 const (
-	EnvExecPid = "docker_pid"
-	EnvExecCmd = "docker_cmd"
+	DefaultVolumePath    = "/var/lib/docker-go/volumes/"
+	VolumeDataDirName    = "_data"
+	VolumeConfigFileName = "volume.json"
+	DefaultVolumeDriver  = "local"
+	DefaultPluginPath    = "/var/run/docker-go/plugins/"
 )
 
 const (
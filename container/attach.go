@@ -0,0 +1,314 @@
+/*
+	attach 重新连接到后台运行的容器
+	后台容器的标准输入输出由容器的 shim 进程持有，shim 中的 console server 负责:
+	1. 容器的输出写入日志文件，同时转发给所有 attach 上来的客户端
+	2. 客户端的输入写入容器的标准输入，-ti 启动的容器则是 pty master
+	3. 在容器信息目录下监听 attach.sock，attach 命令连接它
+	attach 时按下 detach 键序列(默认 ctrl-p ctrl-q)断开连接，容器继续运行
+*/
+
+package container
+
+import (
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDetachKeys  = "ctrl-p,ctrl-q"
+	attachWriteTimeout = 5 * time.Second
+)
+
+// ContainerIO 容器标准输入输出在 docker-go 一侧的一端
+type ContainerIO struct {
+	Console *os.File // tty 时接收 pty master 的 console socket
+	Stdin   *os.File // 非 tty 时写入容器的标准输入
+	Output  *os.File // 非 tty 时读取容器的标准输出和标准错误
+
+	inherited []*os.File // 传给容器 init 进程的另一端
+}
+
+// CloseInherited 容器 init 进程启动后，关闭已经被它继承的文件在当前进程中的副本
+func (c *ContainerIO) CloseInherited() {
+	for _, f := range c.inherited {
+		_ = f.Close()
+	}
+	c.inherited = nil
+}
+
+// 关闭所有的一端
+func (c *ContainerIO) Close() {
+	for _, f := range []*os.File{c.Console, c.Stdin, c.Output} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
+
+// console server 的状态
+type consoleServer struct {
+	input  io.Writer
+	output io.Reader
+	log    *os.File
+
+	mu      sync.Mutex
+	clients map[net.Conn]bool
+}
+
+// 在 shim 进程中运行，容器的输出结束后返回，返回时关闭 containerIO
+func serveConsole(containerName string, containerIO *ContainerIO) error {
+	defer containerIO.Close()
+	server := &consoleServer{clients: make(map[net.Conn]bool)}
+	if containerIO.Console != nil {
+		master, err := ReceiveConsole(containerIO.Console)
+		if err != nil {
+			return err
+		}
+		defer master.Close()
+		server.input, server.output = master, master
+	} else {
+		server.input, server.output = containerIO.Stdin, containerIO.Output
+	}
+
+	dir := path.Join(common.DefaultContainerInfoPath, containerName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(path.Join(dir, common.ContainerLogFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	server.log = logFile
+
+	socketPath := path.Join(dir, common.AttachSocketName)
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	go server.accept(listener)
+
+	server.copyOutput()
+	_ = listener.Close()
+	server.closeClients()
+
+	return nil
+}
+
+func (s *consoleServer) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = true
+		s.mu.Unlock()
+		go s.copyInput(conn)
+	}
+}
+
+// 将客户端的输入写入容器，客户端断开时容器的标准输入保持打开
+func (s *consoleServer) copyInput(conn net.Conn) {
+	_, _ = io.Copy(s.input, conn)
+	s.removeClient(conn)
+}
+
+// 读取容器的输出，写入日志并转发给客户端，容器退出后返回(pty 返回 EIO，管道返回 EOF)
+func (s *consoleServer) copyOutput() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.output.Read(buf)
+		if n > 0 {
+			if _, err := s.log.Write(buf[:n]); err != nil {
+				logrus.Errorf("write container log, err: %v", err)
+			}
+			s.broadcast(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// 转发给所有客户端，写不进去的客户端断开
+func (s *consoleServer) broadcast(bs []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		_ = conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if _, err := conn.Write(bs); err != nil {
+			_ = conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+}
+
+func (s *consoleServer) removeClient(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = conn.Close()
+	delete(s.clients, conn)
+}
+
+func (s *consoleServer) closeClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		_ = conn.Close()
+		delete(s.clients, conn)
+	}
+}
+
+// AttachContainer 连接到容器的标准输入输出，容器退出或者输入 detach 键序列后返回
+func AttachContainer(containerName, detachKeys string) error {
+	keys, err := parseDetachKeys(detachKeys)
+	if err != nil {
+		return err
+	}
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if info.Status != common.Running {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	socketPath := path.Join(common.DefaultContainerInfoPath, containerName, common.AttachSocketName)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("attach container %s: %v", containerName, err)
+	}
+	defer conn.Close()
+
+	// 容器有终端时，用户的终端设为 raw 模式，按键原样发送给容器
+	if info.Config != nil && info.Config.Tty && IsTerminal(os.Stdin) {
+		restore, err := setRawTerminal(int(os.Stdin.Fd()))
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(os.Stdout, conn)
+		close(outputDone)
+	}()
+	detached := make(chan struct{})
+	go func() {
+		if copyUntilDetach(conn, os.Stdin, keys) {
+			close(detached)
+		}
+	}()
+
+	select {
+	case <-outputDone:
+	case <-detached:
+	}
+
+	return nil
+}
+
+// 将 src 复制到 dst，遇到 keys 时停止并返回 true，src 结束时返回 false
+// 与 keys 部分匹配的输入先保留，匹配失败后按 KMP 的方式回退，只发送不再可能匹配的部分
+// src 结束时保留的输入原样发送
+func copyUntilDetach(dst io.Writer, src io.Reader, keys []byte) bool {
+	fallback := detachKeysFallback(keys)
+	buf := make([]byte, 1024)
+	matched := 0
+	for {
+		n, err := src.Read(buf)
+		out := make([]byte, 0, n+matched)
+		for _, b := range buf[:n] {
+			if len(keys) == 0 {
+				out = append(out, b)
+				continue
+			}
+			// 匹配中断，回退到仍然匹配的最长前缀，回退掉的输入原样发送
+			for matched > 0 && b != keys[matched] {
+				next := fallback[matched-1]
+				out = append(out, keys[:matched-next]...)
+				matched = next
+			}
+			if b == keys[matched] {
+				matched++
+				if matched == len(keys) {
+					_, _ = dst.Write(out)
+					return true
+				}
+				continue
+			}
+			out = append(out, b)
+		}
+		if err != nil {
+			out = append(out, keys[:matched]...)
+		}
+		if len(out) > 0 {
+			if _, werr := dst.Write(out); werr != nil {
+				return false
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// keys 的 KMP 失配函数，fallback[i] 为 keys[:i+1] 最长的相同前后缀的长度
+func detachKeysFallback(keys []byte) []int {
+	fallback := make([]int, len(keys))
+	for i, k := 1, 0; i < len(keys); i++ {
+		for k > 0 && keys[i] != keys[k] {
+			k = fallback[k-1]
+		}
+		if keys[i] == keys[k] {
+			k++
+		}
+		fallback[i] = k
+	}
+
+	return fallback
+}
+
+// 解析 detach 键序列，如 ctrl-p,ctrl-q，单个字符表示字符本身
+func parseDetachKeys(keys string) ([]byte, error) {
+	// 为空时不能 detach
+	if keys == "" {
+		return nil, nil
+	}
+	var bs []byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		lower := strings.ToLower(key)
+		switch {
+		case len(key) == 1:
+			bs = append(bs, key[0])
+		case strings.HasPrefix(lower, "ctrl-") && len(key) == 6:
+			c := lower[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				bs = append(bs, c-'a'+1)
+			case c == '@':
+				bs = append(bs, 0)
+			case c >= '[' && c <= '_':
+				bs = append(bs, c-'['+27)
+			default:
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+
+	return bs, nil
+}
@@ -0,0 +1,82 @@
+package container
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	cases := map[string][]byte{
+		"ctrl-p,ctrl-q":  {16, 17},
+		"ctrl-@,a":       {0, 'a'},
+		"ctrl-[, ctrl-_": {27, 31},
+		"":               nil,
+	}
+	for keys, expected := range cases {
+		bs, err := parseDetachKeys(keys)
+		if err != nil {
+			t.Fatalf("parseDetachKeys(%q): %v", keys, err)
+		}
+		if !reflect.DeepEqual(bs, expected) {
+			t.Errorf("parseDetachKeys(%q) = %v, expected %v", keys, bs, expected)
+		}
+	}
+	for _, keys := range []string{"ctrl-1", "alt-p", "ctrl-p,,ctrl-q"} {
+		if _, err := parseDetachKeys(keys); err == nil {
+			t.Errorf("parseDetachKeys(%q) expected error", keys)
+		}
+	}
+}
+
+func TestCopyUntilDetach(t *testing.T) {
+	tests := []struct {
+		keys     string
+		input    string
+		detach   bool
+		expected string
+	}{
+		{"\x10\x11", "ab\x10c\x10\x10\x11rest", true, "ab\x10c\x10"},
+		// src 结束时部分匹配的输入原样发送
+		{"\x10\x11", "abc\x10", false, "abc\x10"},
+		{"\x10\x10\x11", "x\x10\x10", false, "x\x10\x10"},
+		// 重叠的按键序列，匹配中断后回退而不是从当前字节重新开始
+		{"\x10\x10\x11", "a\x10\x10\x10\x11b", true, "a\x10"},
+		{"\x10\x10\x11", "\x10\x10\x10\x10\x11", true, "\x10\x10"},
+		{"abab", "xabaabab", true, "xaba"},
+		{"abac", "ababac!", true, "ab"},
+		{"\x10\x11", "\x10a\x10", false, "\x10a\x10"},
+		{"", "\x10\x11", false, "\x10\x11"},
+	}
+	for _, test := range tests {
+		var dst bytes.Buffer
+		if detach := copyUntilDetach(&dst, strings.NewReader(test.input), []byte(test.keys)); detach != test.detach {
+			t.Errorf("copyUntilDetach(%q, %q) detach = %v, expected %v", test.keys, test.input, detach, test.detach)
+		}
+		if dst.String() != test.expected {
+			t.Errorf("copyUntilDetach(%q, %q) copied %q, expected %q", test.keys, test.input, dst.String(), test.expected)
+		}
+	}
+}
+
+// 每次只读一个字节，部分匹配跨越多次读取
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	return o.r.Read(p[:1])
+}
+
+func TestCopyUntilDetachAcrossReads(t *testing.T) {
+	var dst bytes.Buffer
+	src := &oneByteReader{r: strings.NewReader("a\x10\x10\x10\x11b")}
+	if !copyUntilDetach(&dst, src, []byte("\x10\x10\x11")) {
+		t.Fatal("expected detach")
+	}
+	if dst.String() != "a\x10" {
+		t.Errorf("copied %q, expected %q", dst.String(), "a\x10")
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"docker-go/common"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"os"
 	"os/exec"
 	"path"
+	"time"
 )
 
 func CommitContainer(imageName, imagePath string) error {
+	if err := validateImageName(imageName); err != nil {
+		return err
+	}
 	if imagePath == "" {
 		imagePath = common.RootPath
 	}
@@ -19,5 +24,65 @@ func CommitContainer(imageName, imagePath string) error {
 		logrus.Errorf("tar container image, file name: %s, err: %v", imageTar, err)
 		return err
 	}
+	// 镜像内容已改变，旧的清单和签名不再有效
+	if path.Clean(imagePath) == path.Clean(common.RootPath) {
+		if err := removeImageManifest(imageName); err != nil {
+			logrus.Errorf("remove image manifest, image: %s, err: %v", imageName, err)
+		}
+	}
+	return nil
+}
+
+// CommitContainerLayer 将容器的读写层提交为新的镜像层
+// 新镜像由容器所用镜像的所有层加上该层组成，stopSignal 为空时沿用原镜像的停止信号
+func CommitContainerLayer(containerName, imageName, stopSignal string) error {
+	if err := validateImageName(imageName); err != nil {
+		return err
+	}
+	if imageExists(imageName) {
+		return fmt.Errorf("image %s already exists", imageName)
+	}
+	if stopSignal != "" {
+		if _, err := ParseSignal(stopSignal); err != nil {
+			return err
+		}
+	}
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("get container info, err: %v", err)
+		return err
+	}
+	base, err := getImageManifest(info.Image)
+	if err != nil {
+		logrus.Errorf("get image manifest, image: %s, err: %v", info.Image, err)
+		return err
+	}
+	for _, layer := range base.Layers {
+		if err = ensureLayerBlob(base, layer); err != nil {
+			logrus.Errorf("save layer %s, err: %v", layer.Digest, err)
+			return err
+		}
+	}
+
+	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
+	layer, err := saveLayerDir(writeLayerPath)
+	if err != nil {
+		return err
+	}
+	manifest := &ImageManifest{
+		Name:    imageName,
+		Layers:  append(append([]*ImageLayer{}, base.Layers...), layer),
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+
+		StopSignal: base.StopSignal,
+	}
+	if stopSignal != "" {
+		manifest.StopSignal = stopSignal
+	}
+	if _, err = saveImageManifest(manifest); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "committed %s as %s, layer %s\n", containerName, imageName, layer.Digest)
+
 	return nil
 }
@@ -0,0 +1,115 @@
+/*
+	run -ti 时容器的控制终端
+	pty 必须在容器的 devpts 实例中分配，容器内的 tty、ps 等才能找到它，所以由 init 进程分配:
+	1. docker-go 创建一对 unix socket，一端作为 fd 4 传给 init 进程，init 进程由 setsid 启动，是新会话的首进程
+	2. init 进程挂载好 /dev 后打开 /dev/ptmx，slave 设为控制终端并作为标准输入输出，同时 bind mount 到 /dev/console
+	3. init 进程通过 SCM_RIGHTS 将 master 发送给 docker-go，docker-go 再与用户的终端互相转发
+*/
+
+package container
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// init 进程中 console socket 的 fd，0-2 为标准输入输出，3 为读取命令的管道
+const consoleSocketFd = 4
+
+// 创建 console socket，返回 docker-go 和 init 进程各自使用的一端
+func newConsoleSocket() (*os.File, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create console socket: %v", err)
+	}
+
+	return os.NewFile(uintptr(fds[0]), "console-parent"), os.NewFile(uintptr(fds[1]), "console-child"), nil
+}
+
+// ReceiveConsole 接收 init 进程发送的 pty master，init 进程出错退出时返回错误
+func ReceiveConsole(socket *os.File) (*os.File, error) {
+	defer socket.Close()
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(int(socket.Fd()), buf, oob, 0)
+	if err != nil {
+		return nil, fmt.Errorf("receive console: %v", err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("receive console: container init did not send a console")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("receive console: invalid control message")
+	}
+	syscall.CloseOnExec(fds[0])
+
+	return os.NewFile(uintptr(fds[0]), "console-master"), nil
+}
+
+// ProxyConsole 将用户的终端设为 raw 模式，与 master 互相转发，并同步窗口大小
+// 返回的函数等待容器的输出读完，然后恢复用户的终端
+func ProxyConsole(master *os.File) func() {
+	stdinFd := int(os.Stdin.Fd())
+	restore, err := setRawTerminal(stdinFd)
+	if err != nil {
+		logrus.Errorf("set terminal raw mode, err: %v", err)
+		restore = func() {}
+	}
+	stopResize := handleResize(master, stdinFd)
+	outputDone := proxyPty(master, os.Stdin, os.Stdout)
+
+	return func() {
+		<-outputDone
+		stopResize()
+		restore()
+		_ = master.Close()
+	}
+}
+
+// 在容器中分配 pty 作为控制终端，将 master 发送给 docker-go，必须在 /dev 准备好之后调用
+func setUpConsole() error {
+	socket := os.NewFile(consoleSocketFd, "console-socket")
+	defer socket.Close()
+	master, slave, err := openPty("/dev/ptmx")
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	// /dev/console 指向 slave，与 runc 相同
+	slavePath, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", slave.Fd()))
+	if err != nil {
+		return err
+	}
+	console, err := os.OpenFile("/dev/console", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	_ = console.Close()
+	if err = syscall.Mount(slavePath, "/dev/console", "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s to /dev/console: %v", slavePath, err)
+	}
+
+	// init 进程已经是会话首进程，将 slave 设为控制终端并作为标准输入输出
+	if err = unix.IoctlSetInt(int(slave.Fd()), unix.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("set controlling terminal: %v", err)
+	}
+	for fd := 0; fd <= 2; fd++ {
+		if err = unix.Dup3(int(slave.Fd()), fd, 0); err != nil {
+			return fmt.Errorf("dup console to fd %d: %v", fd, err)
+		}
+	}
+
+	rights := syscall.UnixRights(int(master.Fd()))
+	if err = syscall.Sendmsg(int(socket.Fd()), []byte{0}, rights, nil, 0); err != nil {
+		return fmt.Errorf("send console: %v", err)
+	}
+
+	return nil
+}
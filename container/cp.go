@@ -0,0 +1,717 @@
+/*
+	在宿主机与容器之间拷贝文件，类似 docker cp
+	运行中的容器在一个加入容器 mount namespace 并 chroot 到容器根目录的线程上读写，路径由内核在容器中解析
+	已停止的容器直接操作读写层，读取时叠加镜像只读层并解析白障文件
+	两端之间使用 tar 流传输，保留目录、权限、属主、软链接和拷贝范围内的硬链接
+*/
+
+package container
+
+import (
+	"archive/tar"
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// 解析软链接的最大次数，防止循环链接
+const maxSymlinkHops = 255
+
+// CopyFromContainer 从容器中拷贝文件到宿主机
+func CopyFromContainer(containerName, containerPath, hostPath string) error {
+	srcFS, err := containerFS(containerName)
+	if err != nil {
+		return err
+	}
+	defer srcFS.close()
+	dstFS, dstRel, err := hostFS(hostPath)
+	if err != nil {
+		return err
+	}
+
+	return copyPath(srcFS, relPath(containerPath), followTrailing(containerPath), dstFS, dstRel)
+}
+
+// CopyToContainer 从宿主机拷贝文件到容器中
+func CopyToContainer(hostPath, containerName, containerPath string) error {
+	srcFS, srcRel, err := hostFS(hostPath)
+	if err != nil {
+		return err
+	}
+	dstFS, err := containerFS(containerName)
+	if err != nil {
+		return err
+	}
+	defer dstFS.close()
+
+	return copyPath(srcFS, srcRel, followTrailing(hostPath), dstFS, relPath(containerPath))
+}
+
+// layerFS 由多层目录叠加而成的文件系统视图，layers 从上到下排列
+// 写入只发生在最上层，whiteout 为 true 时解析 aufs/overlay 白障文件
+// calls 不为 nil 时，所有操作都要通过 do 在容器的线程上执行
+type layerFS struct {
+	layers   []string
+	whiteout bool
+	calls    chan *fsCall
+}
+
+// 在容器的线程上执行的操作
+type fsCall struct {
+	fn     func() error
+	result chan error
+}
+
+// 获取容器的文件系统视图
+func containerFS(containerName string) (*layerFS, error) {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("get container info, err: %v", err)
+		return nil, err
+	}
+	// 运行中的容器，进入容器的 mount namespace 读写，容器进程已经退出时按已停止的容器处理
+	if info.Status == common.Running && info.Pid != "" {
+		fs, err := runningContainerFS(info.Pid)
+		if err == nil || !os.IsNotExist(err) {
+			return fs, err
+		}
+	}
+	// 已停止的容器，读写层叠加在镜像只读层之上
+	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
+	if _, err := os.Stat(writeLayerPath); err != nil {
+		logrus.Errorf("stat write layer, path: %s, err: %v", writeLayerPath, err)
+		return nil, err
+	}
+	return &layerFS{
+		layers:   []string{writeLayerPath, path.Join(common.RootPath, info.Image)},
+		whiteout: true,
+	}, nil
+}
+
+// 运行中的容器的文件系统视图
+// 在宿主机上通过 /proc/<pid>/root 解析路径时，容器中的进程可以在解析过程中把路径替换为软链接，指向宿主机上的文件
+// 所以由一个单独的线程执行所有操作，该线程加入容器的 mount namespace 并 chroot 到容器 init 进程的根目录
+// 修改过 namespace 的线程不再 UnlockOSThread，close 之后 goroutine 退出，Go 运行时会销毁该线程
+func runningContainerFS(pid string) (*layerFS, error) {
+	mntNs, err := os.Open(fmt.Sprintf("/proc/%s/ns/mnt", pid))
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.Open(fmt.Sprintf("/proc/%s/root", pid))
+	if err != nil {
+		_ = mntNs.Close()
+		return nil, err
+	}
+
+	calls := make(chan *fsCall)
+	ready := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		err := enterContainerRoot(mntNs, root)
+		_ = mntNs.Close()
+		_ = root.Close()
+		ready <- err
+		if err != nil {
+			return
+		}
+		for call := range calls {
+			call.result <- call.fn()
+		}
+	}()
+	if err = <-ready; err != nil {
+		logrus.Errorf("enter root of container process %s, err: %v", pid, err)
+		return nil, err
+	}
+
+	return &layerFS{layers: []string{"/"}, calls: calls}, nil
+}
+
+// 当前线程加入 mntNs 对应的 mount namespace，并将根目录切换为 root
+func enterContainerRoot(mntNs, root *os.File) error {
+	// 使线程的根目录和工作目录独立，否则多线程进程不能 setns 到 mount namespace
+	if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+		return fmt.Errorf("unshare fs: %v", err)
+	}
+	if err := unix.Setns(int(mntNs.Fd()), syscall.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("setns mnt: %v", err)
+	}
+	if err := syscall.Fchdir(int(root.Fd())); err != nil {
+		return fmt.Errorf("chdir to container root: %v", err)
+	}
+	if err := syscall.Chroot("."); err != nil {
+		return fmt.Errorf("chroot to container root: %v", err)
+	}
+
+	return syscall.Chdir("/")
+}
+
+// 在文件系统所在的线程上执行 fn
+func (l *layerFS) do(fn func() error) error {
+	if l.calls == nil {
+		return fn()
+	}
+	call := &fsCall{fn: fn, result: make(chan error)}
+	l.calls <- call
+
+	return <-call.result
+}
+
+// 结束运行中的容器的线程
+func (l *layerFS) close() {
+	if l.calls != nil {
+		close(l.calls)
+	}
+}
+
+// 宿主机的文件系统视图，返回相对根目录的路径
+func hostFS(hostPath string) (*layerFS, string, error) {
+	absPath, err := filepath.Abs(hostPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return &layerFS{layers: []string{"/"}}, relPath(absPath), nil
+}
+
+// 将源路径拷贝到目标路径
+// 目标为已存在的目录时拷贝到该目录下，否则以目标路径作为新的文件名
+// 源路径为软链接时只拷贝链接本身，除非 followSrc 为 true
+func copyPath(srcFS *layerFS, srcRel string, followSrc bool, dstFS *layerFS, dstRel string) error {
+	err := srcFS.do(func() error {
+		var err error
+		if srcRel, err = srcFS.resolve(srcRel, followSrc); err != nil {
+			return err
+		}
+		if _, _, err = srcFS.lstat(srcRel); err != nil {
+			logrus.Errorf("stat copy source, path: /%s, err: %v", srcRel, err)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	destDir, name := "", path.Base(srcRel)
+	if srcRel == "" {
+		name = ""
+	}
+	err = dstFS.do(func() error {
+		var err error
+		if dstRel, err = dstFS.resolve(dstRel, true); err != nil {
+			return err
+		}
+		destDir = dstRel
+		if _, fi, err := dstFS.lstat(dstRel); err == nil && fi.IsDir() {
+			return nil
+		}
+		destDir, name = path.Dir(dstRel), path.Base(dstRel)
+		if destDir == "." {
+			destDir = ""
+		}
+		_, fi, err := dstFS.lstat(destDir)
+		if err != nil {
+			logrus.Errorf("stat copy destination, path: /%s, err: %v", destDir, err)
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("copy destination /%s is not a directory", destDir)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		_ = writer.CloseWithError(srcFS.do(func() error {
+			return writeTar(srcFS, srcRel, name, writer)
+		}))
+		close(done)
+	}()
+	err = dstFS.do(func() error {
+		return extractTar(reader, dstFS, destDir)
+	})
+	_ = reader.CloseWithError(err)
+	// 等待打包结束，之后才能结束容器的线程
+	<-done
+	if err != nil {
+		logrus.Errorf("copy /%s to /%s, err: %v", srcRel, dstRel, err)
+	}
+
+	return err
+}
+
+// 将 rel 打包为 tar 流写入 w，tar 中以 name 作为顶层名字
+func writeTar(fs *layerFS, rel, name string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	if err := addTarEntry(tw, fs, rel, name, make(map[inodeKey]string)); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// links 记录已经打包的多链接文件，同一个 inode 再次出现时写为硬链接
+func addTarEntry(tw *tar.Writer, fs *layerFS, rel, name string, links map[inodeKey]string) error {
+	absPath, fi, err := fs.lstat(rel)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if ok && fi.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inodeKey{dev: uint64(stat.Dev), ino: stat.Ino}
+			if linkName, ok := links[key]; ok {
+				return writeTarLink(tw, fi, name, linkName)
+			}
+			links[key] = name
+		}
+		if err = writeTarFile(tw, absPath, fi, name); err != nil {
+			return err
+		}
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+
+	names, err := fs.readDir(rel)
+	if err != nil {
+		return err
+	}
+	for _, child := range names {
+		if err = addTarEntry(tw, fs, path.Join(rel, child), path.Join(name, child), links); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 将文件以 name 为名字写入 tar
+func writeTarFile(tw *tar.Writer, absPath string, fi os.FileInfo, name string) error {
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(absPath); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	file, err := os.Open(absPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	_ = file.Close()
+
+	return err
+}
+
+// 将文件以指向 linkName 的硬链接写入 tar
+func writeTarLink(tw *tar.Writer, fi os.FileInfo, name, linkName string) error {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Typeflag = tar.TypeLink
+	hdr.Name, hdr.Linkname = name, linkName
+	hdr.Size = 0
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+	}
+	hdr.Uname, hdr.Gname = "", ""
+
+	return tw.WriteHeader(hdr)
+}
+
+// 将 tar 流解压到 destDir 目录中
+func extractTar(r io.Reader, fs *layerFS, destDir string) error {
+	var dirs []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid tar entry %s", hdr.Name)
+		}
+		// 父目录中的软链接在容器内解析，避免写到容器外
+		parent, err := fs.resolve(path.Join(destDir, path.Dir(name)), true)
+		if err != nil {
+			return err
+		}
+		if err = fs.mkdirTop(parent); err != nil {
+			return err
+		}
+		rel := path.Join(parent, path.Base(name))
+		target := filepath.Join(fs.layers[0], rel)
+		removed := fs.removeWhiteout(rel)
+		// 硬链接指向 tar 中先解压的文件
+		linkSource := ""
+		if hdr.Typeflag == tar.TypeLink {
+			linkName := path.Clean(hdr.Linkname)
+			if path.IsAbs(linkName) || linkName == ".." || strings.HasPrefix(linkName, "../") {
+				return fmt.Errorf("invalid hard link %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			linkRel, err := fs.resolve(path.Join(destDir, linkName), false)
+			if err != nil {
+				return err
+			}
+			linkSource = filepath.Join(fs.layers[0], linkRel)
+		}
+
+		if err = createTarEntry(target, linkSource, hdr, tr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			// 重新创建的目录不能再看到下层被删除的内容
+			if removed {
+				if err = ioutil.WriteFile(filepath.Join(target, whiteoutOpaqueDir), nil, 0444); err != nil {
+					return err
+				}
+			}
+			dirs = append(dirs, hdr)
+		}
+	}
+
+	// 目录的修改时间会被其中的文件改变，最后统一设置
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := fs.resolve(path.Join(destDir, path.Clean(dirs[i].Name)), false)
+		if err != nil {
+			continue
+		}
+		_ = os.Chtimes(filepath.Join(fs.layers[0], rel), dirs[i].AccessTime, dirs[i].ModTime)
+	}
+
+	return nil
+}
+
+// 根据 tar 头信息在 target 创建文件，已存在的文件会被替换
+// 硬链接指向 linkSource
+func createTarEntry(target, linkSource string, hdr *tar.Header, r io.Reader) error {
+	fi, statErr := os.Lstat(target)
+	if statErr == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	mode := uint32(hdr.FileInfo().Mode().Perm())
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if statErr != nil || !fi.IsDir() {
+			if err := os.Mkdir(target, os.FileMode(mode)); err != nil {
+				return err
+			}
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, r)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		if linkSource == "" {
+			return fmt.Errorf("unsupported hard link %s", hdr.Name)
+		}
+		return os.Link(linkSource, target)
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		devMode := mode
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			devMode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			devMode |= syscall.S_IFBLK
+		default:
+			devMode |= syscall.S_IFIFO
+		}
+		dev := int((hdr.Devmajor << 8) | (hdr.Devminor & 0xff) | ((hdr.Devminor & 0xfff00) << 12))
+		if err := syscall.Mknod(target, devMode, dev); err != nil {
+			return err
+		}
+	default:
+		logrus.Warnf("skip unsupported tar entry %s, type: %c", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	// 非 root 用户拷贝时无法修改属主，忽略该错误
+	_ = os.Lchown(target, hdr.Uid, hdr.Gid)
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(target, hdr.FileInfo().Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			_ = os.Chtimes(target, hdr.AccessTime, hdr.ModTime)
+		}
+	}
+
+	return nil
+}
+
+// lstat 获取 rel 在叠加视图中最上层可见的文件
+func (l *layerFS) lstat(rel string) (string, os.FileInfo, error) {
+	for _, layer := range l.layers {
+		if l.whiteout && l.hidden(layer, rel) {
+			break
+		}
+		absPath := filepath.Join(layer, rel)
+		fi, err := os.Lstat(absPath)
+		if err == nil {
+			if l.whiteout && isOverlayWhiteout(fi) {
+				break
+			}
+			return absPath, fi, nil
+		}
+		if l.whiteout && l.opaque(layer, rel) {
+			break
+		}
+	}
+
+	return "", nil, &os.PathError{Op: "lstat", Path: "/" + rel, Err: syscall.ENOENT}
+}
+
+// readDir 合并各层中目录的内容
+func (l *layerFS) readDir(rel string) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, layer := range l.layers {
+		if l.whiteout && l.hidden(layer, rel) {
+			break
+		}
+		dirPath := filepath.Join(layer, rel)
+		fi, err := os.Lstat(dirPath)
+		if err != nil {
+			if l.whiteout && l.opaque(layer, rel) {
+				break
+			}
+			continue
+		}
+		if !fi.IsDir() {
+			break
+		}
+		files, err := ioutil.ReadDir(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := file.Name()
+			if l.whiteout {
+				if strings.HasPrefix(name, whiteoutMetaPrefix) {
+					continue
+				}
+				if strings.HasPrefix(name, whiteoutPrefix) {
+					seen[strings.TrimPrefix(name, whiteoutPrefix)] = true
+					continue
+				}
+				if isOverlayWhiteout(file) {
+					seen[name] = true
+					continue
+				}
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if l.whiteout && (l.opaque(layer, path.Join(rel, whiteoutOpaqueDir)) || isOverlayOpaque(dirPath)) {
+			break
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// hidden rel 或其上级目录是否在 layer 中被白障文件删除
+func (l *layerFS) hidden(layer, rel string) bool {
+	for p := rel; p != "" && p != "."; p = path.Dir(p) {
+		whiteout := filepath.Join(layer, path.Dir(p), whiteoutPrefix+path.Base(p))
+		if _, err := os.Lstat(whiteout); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// opaque rel 的上级目录在 layer 中是否为不透明目录，下层内容不可见
+func (l *layerFS) opaque(layer, rel string) bool {
+	for p := path.Dir(rel); ; p = path.Dir(p) {
+		if p == "." {
+			p = ""
+		}
+		dirPath := filepath.Join(layer, p)
+		if _, err := os.Lstat(filepath.Join(dirPath, whiteoutOpaqueDir)); err == nil {
+			return true
+		}
+		if isOverlayOpaque(dirPath) {
+			return true
+		}
+		if p == "" {
+			return false
+		}
+	}
+}
+
+// resolve 在视图内解析路径中的软链接，绝对路径的链接以视图的根目录为准
+// followLast 为 false 时不解析最后一个路径元素
+func (l *layerFS) resolve(rel string, followLast bool) (string, error) {
+	parts := splitPath(rel)
+	resolved := ""
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		if part == ".." {
+			resolved = path.Dir(resolved)
+			if resolved == "." || resolved == "/" {
+				resolved = ""
+			}
+			continue
+		}
+		next := path.Join(resolved, part)
+		if len(parts) == 0 && !followLast {
+			resolved = next
+			break
+		}
+		absPath, fi, err := l.lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", fmt.Errorf("too many levels of symbolic links in /%s", rel)
+		}
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = ""
+		}
+		parts = append(splitPath(target), parts...)
+	}
+
+	return resolved, nil
+}
+
+// mkdirTop 在最上层中创建 rel 目录，缺失的目录按下层的权限创建
+func (l *layerFS) mkdirTop(rel string) error {
+	current := ""
+	for _, part := range splitPath(rel) {
+		current = path.Join(current, part)
+		dirPath := filepath.Join(l.layers[0], current)
+		if fi, err := os.Lstat(dirPath); err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("/%s is not a directory", current)
+			}
+			continue
+		}
+		_, lower, err := l.lstat(current)
+		if err != nil || !lower.IsDir() {
+			lower = nil
+		}
+		l.removeWhiteout(current)
+		mode := os.FileMode(0755)
+		if lower != nil {
+			mode = lower.Mode().Perm()
+		}
+		if err = os.Mkdir(dirPath, mode); err != nil {
+			return err
+		}
+		if lower != nil {
+			if stat, ok := lower.Sys().(*syscall.Stat_t); ok {
+				_ = os.Lchown(dirPath, int(stat.Uid), int(stat.Gid))
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeWhiteout 删除最上层中 rel 对应的白障文件，返回是否删除
+func (l *layerFS) removeWhiteout(rel string) bool {
+	if !l.whiteout {
+		return false
+	}
+	whiteout := filepath.Join(l.layers[0], path.Dir(rel), whiteoutPrefix+path.Base(rel))
+	if _, err := os.Lstat(whiteout); err != nil {
+		return false
+	}
+
+	return os.Remove(whiteout) == nil
+}
+
+// ParseCopyPath 解析 cp 命令参数，容器路径的格式为 容器名:路径
+// 以 / 或 . 开头、冒号前有路径分隔符的参数，以及 C:\dir 这样的 Windows 路径都是宿主机路径
+func ParseCopyPath(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.ContainsAny(arg[:i], `/\`) || strings.HasPrefix(arg[i+1:], `\`) {
+		return "", arg
+	}
+
+	return arg[:i], arg[i+1:]
+}
+
+// 以 / 或 /. 结尾的源路径需要解析最后的软链接
+func followTrailing(p string) bool {
+	return strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.")
+}
+
+// 转换为相对视图根目录的路径
+func relPath(p string) string {
+	return strings.Join(splitPath(path.Clean("/"+p)), "/")
+}
+
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+
+	return parts
+}
@@ -0,0 +1,159 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseCopyPath(t *testing.T) {
+	tests := []struct {
+		arg       string
+		container string
+		path      string
+	}{
+		{"ctr:/etc/hosts", "ctr", "/etc/hosts"},
+		{"ctr:", "ctr", ""},
+		{"ctr:/a:b", "ctr", "/a:b"},
+		{"/tmp/a:b", "", "/tmp/a:b"},
+		{"./ctr:/etc", "", "./ctr:/etc"},
+		{"dir/ctr:/etc", "", "dir/ctr:/etc"},
+		{"file", "", "file"},
+		{":/etc", "", ":/etc"},
+		{`C:\Users\a`, "", `C:\Users\a`},
+		{`dir\ctr:/etc`, "", `dir\ctr:/etc`},
+	}
+	for _, test := range tests {
+		container, p := ParseCopyPath(test.arg)
+		if container != test.container || p != test.path {
+			t.Errorf("ParseCopyPath(%q) = %q, %q, expected %q, %q", test.arg, container, p, test.container, test.path)
+		}
+	}
+}
+
+// 用 layer_test 中的层文件构造保留白障文件的层目录
+func buildLayerDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	if err := applyLayer(dir, buildLayer(t, files), true); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestLayerFSWhiteout(t *testing.T) {
+	lower := buildLayerDir(t, map[string]string{"a/x": "x", "a/y": "y", "b/z": "z", "c/old": "old", "d/k": "k"})
+	upper := buildLayerDir(t, map[string]string{".wh.b": "", "a/.wh.x": "", "c/.wh..wh..opq": "", "c/new": "new", "n": "n"})
+	_ = os.Symlink("/a", filepath.Join(upper, "link"))
+	_ = os.Mkdir(filepath.Join(upper, "d"), 0755)
+	_ = os.Symlink("../a/y", filepath.Join(upper, "d", "rel"))
+	_ = os.Symlink("loop", filepath.Join(upper, "loop"))
+	fs := &layerFS{layers: []string{upper, lower}, whiteout: true}
+
+	dirs := map[string][]string{
+		"":  {"a", "c", "d", "link", "loop", "n"},
+		"a": {"y"},
+		"c": {"new"},
+		"d": {"k", "rel"},
+	}
+	for dir, expected := range dirs {
+		names, err := fs.readDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("readDir(%q) = %v, expected %v", dir, names, expected)
+		}
+	}
+	for _, rel := range []string{"b", "b/z", "a/x", "c/old"} {
+		if _, _, err := fs.lstat(rel); err == nil {
+			t.Errorf("lstat(%q) should be hidden by whiteout", rel)
+		}
+	}
+	if absPath, _, err := fs.lstat("d/k"); err != nil || absPath != filepath.Join(lower, "d/k") {
+		t.Errorf("lstat(d/k) = %q, %v, expected lower layer", absPath, err)
+	}
+
+	resolves := []struct {
+		rel        string
+		followLast bool
+		expected   string
+	}{
+		{"link/y", true, "a/y"},
+		{"link", true, "a"},
+		{"link", false, "link"},
+		{"d/rel", true, "a/y"},
+		{"../../a/y", true, "a/y"},
+		{"missing/file", true, "missing/file"},
+	}
+	for _, test := range resolves {
+		resolved, err := fs.resolve(test.rel, test.followLast)
+		if err != nil || resolved != test.expected {
+			t.Errorf("resolve(%q, %v) = %q, %v, expected %q", test.rel, test.followLast, resolved, err, test.expected)
+		}
+	}
+	if _, err := fs.resolve("loop", true); err == nil {
+		t.Errorf("resolve(loop) should fail with too many links")
+	}
+}
+
+func TestCopyPath(t *testing.T) {
+	src := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(src, "data", "sub"), 0750)
+	_ = ioutil.WriteFile(filepath.Join(src, "data", "file"), []byte("content"), 0600)
+	_ = ioutil.WriteFile(filepath.Join(src, "data", "sub", "exec"), []byte("#!/bin/sh"), 0755)
+	_ = os.Link(filepath.Join(src, "data", "file"), filepath.Join(src, "data", "sub", "hard"))
+	_ = os.Symlink("../file", filepath.Join(src, "data", "sub", "soft"))
+	srcFS := &layerFS{layers: []string{src}}
+
+	// 目标中 data 已经被删除，拷贝后下层的内容不能再出现
+	lower := buildLayerDir(t, map[string]string{"data/stale": "stale"})
+	upper := buildLayerDir(t, map[string]string{".wh.data": ""})
+	dstFS := &layerFS{layers: []string{upper, lower}, whiteout: true}
+	if err := copyPath(srcFS, "data", false, dstFS, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := dstFS.readDir("data")
+	if expected := []string{"file", "sub"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("copied data = %v, expected %v", names, expected)
+	}
+	if _, err := os.Lstat(filepath.Join(upper, ".wh.data")); err == nil {
+		t.Errorf("whiteout of data should be removed")
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(upper, "data", "file")); string(bs) != "content" {
+		t.Errorf("copied file = %q", bs)
+	}
+	if fi, err := os.Stat(filepath.Join(upper, "data", "sub", "exec")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("copied exec = %v, %v, expected mode 0755", fi, err)
+	}
+	if fi, err := os.Stat(filepath.Join(upper, "data")); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("copied dir = %v, %v, expected mode 0750", fi, err)
+	}
+	if link, _ := os.Readlink(filepath.Join(upper, "data", "sub", "soft")); link != "../file" {
+		t.Errorf("copied symlink = %q, expected ../file", link)
+	}
+	file, _ := os.Stat(filepath.Join(upper, "data", "file"))
+	hard, err := os.Stat(filepath.Join(upper, "data", "sub", "hard"))
+	if err != nil || !os.SameFile(file, hard) {
+		t.Errorf("hard link should be preserved, err: %v", err)
+	}
+	if stat, ok := file.Sys().(*syscall.Stat_t); ok && stat.Nlink != 2 {
+		t.Errorf("copied file has %d links, expected 2", stat.Nlink)
+	}
+
+	// 目标不存在时以目标路径作为新的文件名，拷贝回宿主机
+	back := t.TempDir()
+	if err := copyPath(dstFS, "data/sub", false, &layerFS{layers: []string{back}}, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, back); !reflect.DeepEqual(files, []string{"renamed/exec", "renamed/hard", "renamed/soft"}) {
+		t.Errorf("copied back files = %v", files)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(back, "renamed", "hard")); string(bs) != "content" {
+		t.Errorf("hard link copied alone = %q, expected content", bs)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+	容器的 /dev 目录，init 进程在 pivot_root 之后挂载 tmpfs 到 /dev，再通过 mknod 创建设备节点
+	默认设备为 null、zero、full、random、urandom、tty，另外创建 /dev/fd、/dev/stdin 等符号链接
+	/dev/pts 挂载新的 devpts 实例，与宿主机的终端隔离，/dev/ptmx 指向 pts/ptmx
+	/dev/shm 挂载 64m 的 tmpfs，用于 POSIX 共享内存
+	--device 的格式为 宿主机路径[:容器路径[:权限]]，例如 /dev/sdc:/dev/xvdc:rw
+	没有 devices cgroup，权限中不包含 r 或 w 时只去掉设备节点对应的读写权限位
+*/
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+const (
+	defaultDevicePermissions = "rwm"
+	devPtsOptions            = "newinstance,ptmxmode=0666,mode=0620,gid=5"
+	devShmOptions            = "mode=1777,size=65536k"
+)
+
+// Device 设备节点
+type Device struct {
+	PathOnHost  string `json:"pathOnHost,omitempty"` // 宿主机上的路径，默认设备为空
+	Path        string `json:"path"`                 // 容器内的路径
+	Type        string `json:"type"`                 // c 字符设备，b 块设备
+	Major       int64  `json:"major"`
+	Minor       int64  `json:"minor"`
+	FileMode    uint32 `json:"fileMode"`
+	Uid         uint32 `json:"uid"`
+	Gid         uint32 `json:"gid"`
+	Permissions string `json:"permissions"` // r 读，w 写，m mknod
+}
+
+// 每个容器都有的设备
+var defaultDevices = []*Device{
+	{Path: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666, Permissions: defaultDevicePermissions},
+	{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666, Permissions: defaultDevicePermissions},
+	{Path: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666, Permissions: defaultDevicePermissions},
+	{Path: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666, Permissions: defaultDevicePermissions},
+	{Path: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666, Permissions: defaultDevicePermissions},
+	{Path: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666, Permissions: defaultDevicePermissions},
+}
+
+// /dev 下的符号链接，链接路径 -> 目标
+var defaultDevSymlinks = [][2]string{
+	{"/dev/fd", "/proc/self/fd"},
+	{"/dev/stdin", "/proc/self/fd/0"},
+	{"/dev/stdout", "/proc/self/fd/1"},
+	{"/dev/stderr", "/proc/self/fd/2"},
+	{"/dev/ptmx", "pts/ptmx"},
+}
+
+// ParseDevices 解析 --device 参数，容器内路径不能重复，也不能与默认设备冲突
+func ParseDevices(specs []string) ([]*Device, error) {
+	paths := make(map[string]bool)
+	for _, device := range defaultDevices {
+		paths[device.Path] = true
+	}
+	var devices []*Device
+	for _, spec := range specs {
+		device, err := parseDevice(spec)
+		if err != nil {
+			return nil, err
+		}
+		if paths[device.Path] {
+			return nil, fmt.Errorf("duplicate device %s", device.Path)
+		}
+		paths[device.Path] = true
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// 解析单个 --device 参数，读取宿主机设备的类型和设备号
+func parseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device %q", spec)
+	}
+	hostPath := path.Clean(parts[0])
+	containerPath := hostPath
+	permissions := defaultDevicePermissions
+	if len(parts) > 1 && parts[1] != "" {
+		if isDevicePermissions(parts[1]) && len(parts) == 2 {
+			permissions = parts[1]
+		} else {
+			containerPath = path.Clean(parts[1])
+		}
+	}
+	if len(parts) == 3 {
+		if !isDevicePermissions(parts[2]) {
+			return nil, fmt.Errorf("invalid device %q: invalid permissions %q", spec, parts[2])
+		}
+		permissions = parts[2]
+	}
+	if !path.IsAbs(containerPath) || !strings.HasPrefix(containerPath, "/dev/") {
+		return nil, fmt.Errorf("invalid device %q: container path must be under /dev", spec)
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(hostPath, &stat); err != nil {
+		return nil, fmt.Errorf("invalid device %q: %v", spec, err)
+	}
+	device := &Device{
+		PathOnHost:  hostPath,
+		Path:        containerPath,
+		Major:       devMajor(stat.Rdev),
+		Minor:       devMinor(stat.Rdev),
+		FileMode:    stat.Mode &^ syscall.S_IFMT,
+		Uid:         stat.Uid,
+		Gid:         stat.Gid,
+		Permissions: permissions,
+	}
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		device.Type = "c"
+	case syscall.S_IFBLK:
+		device.Type = "b"
+	default:
+		return nil, fmt.Errorf("invalid device %q: %s is not a device", spec, hostPath)
+	}
+
+	return device, nil
+}
+
+// 权限只能由 r、w、m 组成
+func isDevicePermissions(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(defaultDevicePermissions, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// 在容器的 /dev 中创建设备节点、符号链接，并挂载 devpts 和 shm，必须在 /dev 挂载 tmpfs 之后调用
+func setUpDev(devices []*Device) error {
+	// 设备节点的权限由 FileMode 决定，不受 umask 影响
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+
+	for _, device := range append(defaultDevices, devices...) {
+		if err := createDevice(device); err != nil {
+			return err
+		}
+	}
+	for _, link := range defaultDevSymlinks {
+		if err := os.Symlink(link[1], link[0]); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("symlink %s -> %s: %v", link[0], link[1], err)
+		}
+	}
+
+	// 新的 devpts 实例，容器中打开的终端不会出现在宿主机的 /dev/pts 中
+	if err := os.MkdirAll("/dev/pts", 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("devpts", "/dev/pts", "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, devPtsOptions); err != nil {
+		return fmt.Errorf("mount devpts: %v", err)
+	}
+	if err := os.MkdirAll("/dev/shm", 01777); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("shm", "/dev/shm", "tmpfs", flags, devShmOptions); err != nil {
+		return fmt.Errorf("mount /dev/shm: %v", err)
+	}
+
+	return nil
+}
+
+// 通过 mknod 创建设备节点
+func createDevice(device *Device) error {
+	if err := os.MkdirAll(path.Dir(device.Path), 0755); err != nil {
+		return err
+	}
+	mode := device.FileMode
+	// 去掉没有授予的读写权限
+	if !strings.Contains(device.Permissions, "r") {
+		mode &^= 0444
+	}
+	if !strings.Contains(device.Permissions, "w") {
+		mode &^= 0222
+	}
+	switch device.Type {
+	case "c":
+		mode |= syscall.S_IFCHR
+	case "b":
+		mode |= syscall.S_IFBLK
+	default:
+		return fmt.Errorf("unsupported device type %q of %s", device.Type, device.Path)
+	}
+	if err := syscall.Mknod(device.Path, mode, int(devMkdev(device.Major, device.Minor))); err != nil {
+		return fmt.Errorf("mknod %s: %v", device.Path, err)
+	}
+
+	return os.Chown(device.Path, int(device.Uid), int(device.Gid))
+}
+
+// 与 glibc 的 major、minor、makedev 相同
+func devMajor(dev uint64) int64 {
+	return int64((dev>>8)&0xfff | (dev>>32)&^0xfff)
+}
+
+func devMinor(dev uint64) int64 {
+	return int64(dev&0xff | (dev>>12)&^0xff)
+}
+
+func devMkdev(major, minor int64) uint64 {
+	ma, mi := uint64(major), uint64(minor)
+	return (ma&0xfff)<<8 | (ma&^0xfff)<<32 | mi&0xff | (mi&^0xff)<<12
+}
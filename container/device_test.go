@@ -0,0 +1,46 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	devices, err := ParseDevices([]string{"/dev/zero:/dev/myzero:r", "/dev/full:/dev/xfull", "/dev/random:/dev/hwrng"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("devices = %d, expected 3", len(devices))
+	}
+	zero := devices[0]
+	if zero.PathOnHost != "/dev/zero" || zero.Path != "/dev/myzero" || zero.Type != "c" ||
+		zero.Major != 1 || zero.Minor != 5 || zero.Permissions != "r" {
+		t.Errorf("device = %+v", zero)
+	}
+	if devices[1].Permissions != defaultDevicePermissions {
+		t.Errorf("permissions = %q, expected %q", devices[1].Permissions, defaultDevicePermissions)
+	}
+
+	invalid := [][]string{
+		{"/dev/zero:/dev/zero0:rwx"},
+		{"/dev/zero:/tmp/zero"},
+		{"/dev/null"},
+		{"/dev/zero:/dev/z", "/dev/full:/dev/z"},
+		{"/etc/hostname:/dev/hostname"},
+		{"/dev/does-not-exist:/dev/x"},
+	}
+	for _, specs := range invalid {
+		if _, err := ParseDevices(specs); err == nil {
+			t.Errorf("ParseDevices(%q) expected error", specs)
+		}
+	}
+}
+
+func TestDevNumbers(t *testing.T) {
+	for _, n := range [][2]int64{{1, 3}, {8, 16}, {259, 0}, {4095, 1048575}} {
+		dev := devMkdev(n[0], n[1])
+		if devMajor(dev) != n[0] || devMinor(dev) != n[1] {
+			t.Errorf("mkdev(%d, %d) = %d, major %d, minor %d", n[0], n[1], dev, devMajor(dev), devMinor(dev))
+		}
+	}
+}
@@ -0,0 +1,269 @@
+/*
+	磁盘使用情况统计，类似 docker system df
+	镜像: 解压后的镜像目录，多个镜像通过硬链接共用的文件计入共享空间
+	容器: 读写层与日志文件
+	数据卷: 宿主机上挂载到容器中的目录，以及所有命名数据卷
+*/
+
+package container
+
+import (
+	"docker-go/common"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+)
+
+// DiskUsage 磁盘使用情况
+type DiskUsage struct {
+	Images     []*ImageUsage     `json:"images"`
+	Containers []*ContainerUsage `json:"containers"`
+	Volumes    []*VolumeUsage    `json:"volumes"`
+
+	imagesSize int64 // 所有镜像占用的空间，共享的文件只统计一次
+}
+
+// ImageUsage 镜像占用的空间
+type ImageUsage struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	SharedSize int64  `json:"sharedSize"` // 与其它镜像共用的空间
+	UniqueSize int64  `json:"uniqueSize"` // 该镜像独占的空间
+	Containers int    `json:"containers"` // 使用该镜像的容器数
+}
+
+// ContainerUsage 容器占用的空间
+type ContainerUsage struct {
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	Status  string `json:"status"`
+	Size    int64  `json:"size"`    // 读写层大小
+	LogSize int64  `json:"logSize"` // 日志文件大小
+}
+
+// VolumeUsage 数据卷占用的空间
+type VolumeUsage struct {
+	Name       string `json:"name,omitempty"` // 命名数据卷的名字，绑定宿主机目录时为空
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Containers int    `json:"containers"` // 使用该数据卷的容器数
+}
+
+// 文件的唯一标识
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+// SystemDiskUsage 打印磁盘使用情况
+func SystemDiskUsage(verbose, jsonOutput bool) error {
+	usage := GetDiskUsage()
+	if jsonOutput {
+		bs, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(os.Stdout, string(bs))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 2, ' ', 0)
+	printDiskUsageSummary(w, usage)
+	if verbose {
+		printDiskUsageDetail(w, usage)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush disk usage, err: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetDiskUsage 统计镜像、容器、数据卷和日志占用的空间
+func GetDiskUsage() *DiskUsage {
+	infos := listContainerInfos()
+	usage := &DiskUsage{
+		Containers: []*ContainerUsage{},
+		Volumes:    []*VolumeUsage{},
+	}
+
+	imageContainers := make(map[string]int)
+	volumeContainers := make(map[string]int)
+	for _, info := range infos {
+		imageContainers[info.Image]++
+		for _, volume := range info.volumes() {
+			volumeContainers[volume.Source]++
+		}
+		usage.Containers = append(usage.Containers, &ContainerUsage{
+			Name:    info.Name,
+			Image:   info.Image,
+			Status:  info.Status,
+			Size:    dirSize(path.Join(common.RootPath, common.WriteLayer, info.Name)),
+			LogSize: dirSize(path.Join(common.DefaultContainerInfoPath, info.Name, common.ContainerLogFileName)),
+		})
+	}
+
+	imageDirs := make(map[string]string)
+	for _, file := range listImageDirs() {
+		imageDirs[file.Name()] = path.Join(common.RootPath, file.Name())
+	}
+	usage.Images, usage.imagesSize = imageDiskUsage(imageDirs, imageContainers)
+
+	// 没有被容器引用的命名数据卷同样占用空间
+	volumeNames := make(map[string]string)
+	for _, volume := range listVolumes() {
+		volumeNames[volume.Mountpoint] = volume.Name
+		if _, ok := volumeContainers[volume.Mountpoint]; !ok {
+			volumeContainers[volume.Mountpoint] = 0
+		}
+	}
+	for hostPath, containers := range volumeContainers {
+		usage.Volumes = append(usage.Volumes, &VolumeUsage{
+			Name:       volumeNames[hostPath],
+			Path:       hostPath,
+			Size:       dirSize(hostPath),
+			Containers: containers,
+		})
+	}
+	sort.Slice(usage.Volumes, func(i, j int) bool {
+		return usage.Volumes[i].Path < usage.Volumes[j].Path
+	})
+
+	return usage
+}
+
+// 统计镜像占用的空间，imageDirs 为镜像名到解压目录的映射
+// 通过硬链接被多个镜像使用的文件计入共享空间，返回值中的总大小对共享的文件只统计一次
+func imageDiskUsage(imageDirs map[string]string, imageContainers map[string]int) ([]*ImageUsage, int64) {
+	var total int64
+	// 统计每个文件被多少个镜像使用
+	imageInodes := make(map[string]map[inodeKey]int64)
+	inodeImages := make(map[inodeKey]int)
+	for name, dir := range imageDirs {
+		inodes := dirInodes(dir)
+		imageInodes[name] = inodes
+		for key, size := range inodes {
+			if inodeImages[key] == 0 {
+				total += size
+			}
+			inodeImages[key]++
+		}
+	}
+	images := []*ImageUsage{}
+	for name, inodes := range imageInodes {
+		image := &ImageUsage{Name: name, Containers: imageContainers[name]}
+		for key, size := range inodes {
+			image.Size += size
+			if inodeImages[key] > 1 {
+				image.SharedSize += size
+			}
+		}
+		image.UniqueSize = image.Size - image.SharedSize
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Name < images[j].Name
+	})
+
+	return images, total
+}
+
+func printDiskUsageSummary(w io.Writer, usage *DiskUsage) {
+	_, _ = fmt.Fprint(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE\n")
+
+	var imageActive int
+	var imageReclaimable int64
+	for _, image := range usage.Images {
+		if image.Containers > 0 {
+			imageActive++
+		} else {
+			imageReclaimable += image.UniqueSize
+		}
+	}
+	printDiskUsageRow(w, "Images", len(usage.Images), imageActive, usage.imagesSize, imageReclaimable)
+
+	var containerActive int
+	var containerSize, containerReclaimable, logSize, logReclaimable int64
+	for _, c := range usage.Containers {
+		containerSize += c.Size
+		logSize += c.LogSize
+		if c.Status == common.Running {
+			containerActive++
+		} else {
+			containerReclaimable += c.Size
+			logReclaimable += c.LogSize
+		}
+	}
+	printDiskUsageRow(w, "Containers", len(usage.Containers), containerActive, containerSize, containerReclaimable)
+
+	var volumeActive int
+	var volumeSize, volumeReclaimable int64
+	for _, volume := range usage.Volumes {
+		volumeSize += volume.Size
+		if volume.Containers > 0 {
+			volumeActive++
+		} else {
+			volumeReclaimable += volume.Size
+		}
+	}
+	printDiskUsageRow(w, "Local Volumes", len(usage.Volumes), volumeActive, volumeSize, volumeReclaimable)
+
+	printDiskUsageRow(w, "Logs", len(usage.Containers), containerActive, logSize, logReclaimable)
+}
+
+func printDiskUsageRow(w io.Writer, kind string, total, active int, size, reclaimable int64) {
+	percent := 0
+	if size > 0 {
+		percent = int(reclaimable * 100 / size)
+	}
+	_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s (%d%%)\n", kind, total, active, humanSize(size), humanSize(reclaimable), percent)
+}
+
+func printDiskUsageDetail(w io.Writer, usage *DiskUsage) {
+	_, _ = fmt.Fprint(w, "\nImages space usage:\n\n")
+	_, _ = fmt.Fprint(w, "IMAGE\tSIZE\tSHARED SIZE\tUNIQUE SIZE\tCONTAINERS\n")
+	for _, image := range usage.Images {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", image.Name, humanSize(image.Size),
+			humanSize(image.SharedSize), humanSize(image.UniqueSize), image.Containers)
+	}
+
+	_, _ = fmt.Fprint(w, "\nContainers space usage:\n\n")
+	_, _ = fmt.Fprint(w, "NAME\tIMAGE\tSTATUS\tSIZE\tLOG SIZE\n")
+	for _, c := range usage.Containers {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Name, c.Image, c.Status, humanSize(c.Size), humanSize(c.LogSize))
+	}
+
+	_, _ = fmt.Fprint(w, "\nLocal Volumes space usage:\n\n")
+	_, _ = fmt.Fprint(w, "VOLUME\tLINKS\tSIZE\n")
+	for _, volume := range usage.Volumes {
+		name := volume.Name
+		if name == "" {
+			name = volume.Path
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\n", name, volume.Containers, humanSize(volume.Size))
+	}
+}
+
+// 统计目录下每个文件的大小
+func dirInodes(dirPath string) map[inodeKey]int64 {
+	inodes := make(map[inodeKey]int64)
+	_ = filepath.Walk(dirPath, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+			inodes[inodeKey{dev: uint64(stat.Dev), ino: stat.Ino}] = fi.Size()
+		}
+		return nil
+	})
+
+	return inodes
+}
@@ -0,0 +1,44 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImageDiskUsage(t *testing.T) {
+	base, app := t.TempDir(), t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(base, "lib"), []byte(strings.Repeat("l", 100)), 0644)
+	_ = ioutil.WriteFile(filepath.Join(base, "base"), []byte(strings.Repeat("b", 10)), 0644)
+	_ = ioutil.WriteFile(filepath.Join(app, "app"), []byte(strings.Repeat("a", 20)), 0644)
+	// lib 通过硬链接被两个镜像共用，app 内部的硬链接只统计一次
+	_ = os.Mkdir(filepath.Join(app, "usr"), 0755)
+	_ = os.Link(filepath.Join(base, "lib"), filepath.Join(app, "usr", "lib"))
+	_ = os.Link(filepath.Join(app, "app"), filepath.Join(app, "usr", "app"))
+
+	if inodes := dirInodes(app); len(inodes) != 2 {
+		t.Errorf("dirInodes(app) = %v, expected 2 files", inodes)
+	}
+
+	images, total := imageDiskUsage(map[string]string{"base": base, "app": app}, map[string]int{"app": 2})
+	expected := []*ImageUsage{
+		{Name: "app", Size: 120, SharedSize: 100, UniqueSize: 20, Containers: 2},
+		{Name: "base", Size: 110, SharedSize: 100, UniqueSize: 10},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		for _, image := range images {
+			t.Logf("%+v", image)
+		}
+		t.Errorf("imageDiskUsage images differ from expected")
+	}
+	if total != 130 {
+		t.Errorf("imageDiskUsage total = %d, expected 130", total)
+	}
+
+	if images, total = imageDiskUsage(nil, nil); len(images) != 0 || images == nil || total != 0 {
+		t.Errorf("imageDiskUsage(nil) = %v, %d, expected empty list", images, total)
+	}
+}
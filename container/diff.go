@@ -0,0 +1,186 @@
+/*
+	查看容器文件系统的变更，类似 docker diff
+	遍历容器的读写层，与镜像只读层对比，并解析白障(whiteout)文件
+	输出 A(新增) C(修改) D(删除) 三类变更
+*/
+
+package container
+
+import (
+	"docker-go/common"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// 变更类型
+const (
+	ChangeAdd    = "A"
+	ChangeModify = "C"
+	ChangeDelete = "D"
+)
+
+// aufs 白障文件，读写层中 .wh.name 表示下层的 name 被删除
+// .wh..wh..opq 表示该目录为不透明目录，下层目录中的内容全部不可见
+// 其它 .wh..wh. 开头的为 aufs 内部使用的文件，不属于容器变更
+const (
+	whiteoutPrefix     = ".wh."
+	whiteoutMetaPrefix = ".wh..wh."
+	whiteoutOpaqueDir  = ".wh..wh..opq"
+)
+
+// overlay 不透明目录的扩展属性
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+// Change 文件变更
+type Change struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+// DiffContainer 打印容器相对镜像的文件变更
+func DiffContainer(containerName string, jsonOutput bool) error {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("get container info, err: %v", err)
+		return err
+	}
+	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
+	imagePath := path.Join(common.RootPath, info.Image)
+	changes, err := layerChanges(writeLayerPath, imagePath)
+	if err != nil {
+		logrus.Errorf("get layer changes, path: %s, err: %v", writeLayerPath, err)
+		return err
+	}
+
+	if jsonOutput {
+		if changes == nil {
+			changes = []Change{}
+		}
+		bs, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(os.Stdout, string(bs))
+		return nil
+	}
+	for _, change := range changes {
+		_, _ = fmt.Fprintf(os.Stdout, "%s %s\n", change.Kind, change.Path)
+	}
+
+	return nil
+}
+
+// layerChanges 对比读写层和只读层，得到按路径排序的变更列表
+func layerChanges(layerPath, lowerPath string) ([]Change, error) {
+	if _, err := os.Stat(layerPath); err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]string)
+	err := filepath.Walk(layerPath, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layerPath, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.Base(rel)
+		dir := filepath.Dir(rel)
+
+		// 不透明目录，下层目录中读写层没有的内容都视为删除
+		if name == whiteoutOpaqueDir {
+			return opaqueChanges(kinds, layerPath, lowerPath, dir)
+		}
+		// aufs 内部文件
+		if strings.HasPrefix(name, whiteoutMetaPrefix) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// aufs 白障文件
+		if strings.HasPrefix(name, whiteoutPrefix) {
+			kinds[changePath(filepath.Join(dir, strings.TrimPrefix(name, whiteoutPrefix)))] = ChangeDelete
+			return nil
+		}
+		// overlay 白障文件为设备号 0/0 的字符设备
+		if isOverlayWhiteout(fi) {
+			kinds[changePath(rel)] = ChangeDelete
+			return nil
+		}
+
+		kind := ChangeAdd
+		if _, err := os.Lstat(filepath.Join(lowerPath, rel)); err == nil {
+			kind = ChangeModify
+		}
+		kinds[changePath(rel)] = kind
+
+		if fi.IsDir() && isOverlayOpaque(filePath) {
+			return opaqueChanges(kinds, layerPath, lowerPath, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for p, kind := range kinds {
+		changes = append(changes, Change{Path: p, Kind: kind})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// opaqueChanges 记录不透明目录中被隐藏的下层文件
+func opaqueChanges(kinds map[string]string, layerPath, lowerPath, dir string) error {
+	lowerFiles, err := ioutil.ReadDir(filepath.Join(lowerPath, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, file := range lowerFiles {
+		rel := filepath.Join(dir, file.Name())
+		if _, err := os.Lstat(filepath.Join(layerPath, rel)); err == nil {
+			continue
+		}
+		kinds[changePath(rel)] = ChangeDelete
+	}
+
+	return nil
+}
+
+func isOverlayWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+func isOverlayOpaque(dirPath string) bool {
+	buf := make([]byte, 1)
+	n, err := syscall.Getxattr(dirPath, overlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// 变更路径统一为容器内的绝对路径
+func changePath(rel string) string {
+	return "/" + filepath.ToSlash(rel)
+}
@@ -0,0 +1,51 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLayerChanges(t *testing.T) {
+	lower := t.TempDir()
+	upper := t.TempDir()
+	for _, dir := range []string{"etc", "var/cache/apk", "tmp"} {
+		_ = os.MkdirAll(filepath.Join(lower, dir), 0755)
+	}
+	for _, file := range []string{"etc/hosts", "etc/passwd", "var/cache/apk/a", "var/cache/apk/b"} {
+		_ = os.WriteFile(filepath.Join(lower, file), []byte("lower"), 0644)
+	}
+
+	_ = os.MkdirAll(filepath.Join(upper, "etc"), 0755)
+	_ = os.MkdirAll(filepath.Join(upper, "var/cache/apk"), 0755)
+	_ = os.MkdirAll(filepath.Join(upper, "root"), 0755)
+	_ = os.MkdirAll(filepath.Join(upper, whiteoutMetaPrefix+"plnk"), 0755)
+	_ = os.WriteFile(filepath.Join(upper, "etc/hosts"), []byte("upper"), 0644)
+	_ = os.WriteFile(filepath.Join(upper, "etc", whiteoutPrefix+"passwd"), nil, 0644)
+	_ = os.WriteFile(filepath.Join(upper, "var/cache/apk", whiteoutOpaqueDir), nil, 0644)
+	_ = os.WriteFile(filepath.Join(upper, "var/cache/apk/b"), []byte("upper"), 0644)
+	_ = os.WriteFile(filepath.Join(upper, "root/.ash_history"), []byte("ls"), 0644)
+	_ = os.WriteFile(filepath.Join(upper, whiteoutPrefix+"tmp"), nil, 0644)
+
+	changes, err := layerChanges(upper, lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/etc", Kind: ChangeModify},
+		{Path: "/etc/hosts", Kind: ChangeModify},
+		{Path: "/etc/passwd", Kind: ChangeDelete},
+		{Path: "/root", Kind: ChangeAdd},
+		{Path: "/root/.ash_history", Kind: ChangeAdd},
+		{Path: "/tmp", Kind: ChangeDelete},
+		{Path: "/var", Kind: ChangeModify},
+		{Path: "/var/cache", Kind: ChangeModify},
+		{Path: "/var/cache/apk", Kind: ChangeModify},
+		{Path: "/var/cache/apk/a", Kind: ChangeDelete},
+		{Path: "/var/cache/apk/b", Kind: ChangeModify},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes = %v, expected %v", changes, expected)
+	}
+}
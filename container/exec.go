@@ -1,36 +1,207 @@
+/*
+	exec 进入运行中的容器执行命令，不依赖 cgo
+	setns 只改变调用线程的 namespace，所以先 LockOSThread 固定到一个线程上:
+	1. 加入容器 init 进程所在的 cgroup，之后 fork 出的进程继承这些 cgroup
+	2. unshare(CLONE_FS) 使线程的根目录和工作目录独立，否则多线程进程不能 setns 到 mount namespace
+	3. 依次 setns 到容器的 ipc、uts、net、pid、mnt namespace，fchdir 到容器 init 进程的工作目录
+	4. 在这个线程上 fork 执行命令，子进程继承线程的 namespace，pid namespace 只对子进程生效
+	-ti 时在容器的 devpts 中分配 pty，命令在新的会话中运行，pty 作为它的控制终端
+	修改过 namespace 的线程不再 UnlockOSThread，goroutine 退出时 Go 运行时会销毁该线程
+*/
+
 package container
 
 import (
-	"docker-go/common"
+	"docker-go/cgroups/subsystem"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
-// ExecContainer 从新进入容器
-// 通过设置环境变量的方式，让C语言写的程序真正执行
-// 通过 setns 的系统调用，重新进入到指定的 PID 的 namespace 中
-func ExecContainer(containerName string, cmdArray []string) {
+// 容器中没有设置 PATH 时使用的默认值
+const defaultPathEnv = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// 需要加入的 namespace，mnt 必须最后加入，加入后 /proc 就变成了容器中的 /proc
+var execNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"ipc", syscall.CLONE_NEWIPC},
+	{"uts", syscall.CLONE_NEWUTS},
+	{"net", syscall.CLONE_NEWNET},
+	{"pid", syscall.CLONE_NEWPID},
+	{"mnt", syscall.CLONE_NEWNS},
+}
+
+// ExecContainer 进入容器执行命令，返回命令的退出码，命令被信号杀死时为 128 + 信号值
+// tty 为 true 时为命令分配伪终端
+func ExecContainer(containerName string, cmdArray []string, tty bool) (int, error) {
+	if tty && !IsTerminal(os.Stdin) {
+		return -1, fmt.Errorf("the input device is not a TTY")
+	}
 	info, err := getContainerInfo(containerName)
 	if err != nil {
-		logrus.Errorf("get container info, err: %v", err)
+		return -1, fmt.Errorf("get container info, err: %v", err)
+	}
+	if info.Pid == "" {
+		return -1, fmt.Errorf("container %s is not running", containerName)
+	}
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil {
+		return -1, fmt.Errorf("invalid pid %q of container %s", info.Pid, containerName)
+	}
+	// 容器的工作目录和 namespace 都要在 setns 之前通过宿主机的 /proc 获取
+	envs := execEnv(info)
+	cwd, err := os.Open(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		return -1, fmt.Errorf("open working directory of container %s: %v", containerName, err)
+	}
+	defer cwd.Close()
+	var nsFiles []*os.File
+	defer func() {
+		for _, f := range nsFiles {
+			_ = f.Close()
+		}
+	}()
+	for _, ns := range execNamespaces {
+		f, err := os.Open(fmt.Sprintf("/proc/%d/ns/%s", pid, ns.name))
+		if err != nil {
+			return -1, fmt.Errorf("open %s namespace of container %s: %v", ns.name, containerName, err)
+		}
+		nsFiles = append(nsFiles, f)
+	}
+	if err = subsystem.JoinCgroupsOf(pid, os.Getpid()); err != nil {
+		return -1, fmt.Errorf("join cgroups of container %s: %v", containerName, err)
 	}
-	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
-	err = os.Setenv(common.EnvExecPid, info.Pid)
-	err = os.Setenv(common.EnvExecCmd, strings.Join(cmdArray, " "))
-	// 设置环境变量
-	envs := getEnvsByPid(info.Pid)
-	cmd.Env = append(os.Environ(), envs...)
 
-	if err = cmd.Run(); err != nil {
-		logrus.Errorf("exec cmd run, err: %v", err)
+	runtime.LockOSThread()
+	if err = syscall.Unshare(syscall.CLONE_FS); err != nil {
+		return -1, fmt.Errorf("unshare fs: %v", err)
 	}
+	for i, ns := range execNamespaces {
+		if err = unix.Setns(int(nsFiles[i].Fd()), ns.flag); err != nil {
+			return -1, fmt.Errorf("setns %s: %v", ns.name, err)
+		}
+	}
+	if err = syscall.Fchdir(int(cwd.Fd())); err != nil {
+		return -1, fmt.Errorf("chdir to working directory of container: %v", err)
+	}
+
+	cmdPath, err := lookPath(cmdArray[0], envs)
+	if err != nil {
+		return -1, err
+	}
+	cmd := &exec.Cmd{
+		Path:   cmdPath,
+		Args:   cmdArray,
+		Env:    envs,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	var master, slave *os.File
+	if tty {
+		// 加入 mount namespace 之后打开的是容器中的 /dev/ptmx
+		if master, slave, err = openPty("/dev/ptmx"); err != nil {
+			return -1, err
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	}
+	err = cmd.Start()
+	if slave != nil {
+		// 子进程已经持有 slave，关闭自己的副本，命令退出后读取 master 才会结束
+		_ = slave.Close()
+	}
+	if err != nil {
+		if master != nil {
+			_ = master.Close()
+		}
+		return -1, fmt.Errorf("exec %s: %v", cmdArray[0], err)
+	}
+	logrus.Infof("exec %s in container %s, pid: %d", strings.Join(cmdArray, " "), containerName, cmd.Process.Pid)
+
+	// 将收到的信号转发给命令
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	if !tty {
+		return waitExitCode(cmd.Wait())
+	}
+	wait := ProxyConsole(master)
+	code, err := waitExitCode(cmd.Wait())
+	wait()
+
+	return code, err
+}
+
+// 根据 cmd.Wait 的结果计算退出码
+func waitExitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1, err
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return exitErr.ExitCode(), nil
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+
+	return status.ExitStatus(), nil
+}
+
+// 使用容器的 PATH 查找命令，必须在加入容器的 mount namespace 之后调用
+func lookPath(file string, envs []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	pathEnv := defaultPathEnv
+	for _, env := range envs {
+		if strings.HasPrefix(env, "PATH=") {
+			pathEnv = strings.TrimPrefix(env, "PATH=")
+		}
+	}
+	for _, dir := range strings.Split(pathEnv, ":") {
+		if dir == "" {
+			dir = "."
+		}
+		p := path.Join(dir, file)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("executable file %s not found in $PATH", file)
+}
+
+// exec 命令的环境变量，使用容器启动时记录的环境变量
+// --init 时 init 进程是 docker-go init 而不是用户命令，不能从 /proc 读取，只有没有记录配置的旧容器才从 /proc 读取
+func execEnv(info *ContainerInfo) []string {
+	if info.Config != nil && info.Config.Env != nil {
+		return info.Config.Env
+	}
+
+	return getEnvsByPid(info.Pid)
 }
 
 // 通过 pid 获取进程环境变量
@@ -41,10 +212,18 @@ func getEnvsByPid(pid string) []string {
 		logrus.Errorf("open env file, path: %s, err: %v", envFilePath, err)
 		return nil
 	}
+	defer file.Close()
 	bs, err := ioutil.ReadAll(file)
 	if err != nil {
 		logrus.Errorf("read env file, err: %v", err)
 	}
 
-	return strings.Split(string(bs), "\u0000")
+	var envs []string
+	for _, env := range strings.Split(string(bs), "\u0000") {
+		if env != "" {
+			envs = append(envs, env)
+		}
+	}
+
+	return envs
 }
@@ -0,0 +1,92 @@
+package container
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestLookPath(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(first, "noexec"), nil, 0644)
+	_ = os.Mkdir(filepath.Join(first, "dir"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(first, "tool"), nil, 0755)
+	_ = ioutil.WriteFile(filepath.Join(second, "tool"), nil, 0755)
+	_ = ioutil.WriteFile(filepath.Join(second, "noexec"), nil, 0755)
+	_ = ioutil.WriteFile(filepath.Join(second, "dir"), nil, 0755)
+	envs := []string{"HOME=/root", "PATH=/missing:" + first + ":" + second}
+
+	tests := map[string]string{
+		"tool":        filepath.Join(first, "tool"),
+		"noexec":      filepath.Join(second, "noexec"),
+		"dir":         filepath.Join(second, "dir"),
+		"./local":     "./local",
+		"/bin/absent": "/bin/absent",
+	}
+	for file, expected := range tests {
+		if p, err := lookPath(file, envs); err != nil || p != expected {
+			t.Errorf("lookPath(%s) = %q, %v, expected %q", file, p, err, expected)
+		}
+	}
+	if p, err := lookPath("absent", envs); err == nil {
+		t.Errorf("lookPath(absent) = %q, expected error", p)
+	}
+	// 没有 PATH 时使用默认值，不能使用宿主机的 PATH
+	if p, err := lookPath("tool", []string{"HOME=/root"}); err == nil {
+		t.Errorf("lookPath(tool) without PATH = %q, expected error", p)
+	}
+	if _, err := lookPath("sh", nil); err != nil {
+		t.Errorf("lookPath(sh) with default PATH, err: %v", err)
+	}
+}
+
+func TestWaitExitCode(t *testing.T) {
+	if code, err := waitExitCode(exec.Command("sh", "-c", "exit 0").Run()); code != 0 || err != nil {
+		t.Errorf("exit 0 = %d, %v", code, err)
+	}
+	if code, err := waitExitCode(exec.Command("sh", "-c", "exit 3").Run()); code != 3 || err != nil {
+		t.Errorf("exit 3 = %d, %v, expected 3", code, err)
+	}
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Process.Signal(syscall.SIGKILL)
+	if code, err := waitExitCode(cmd.Wait()); code != 128+int(syscall.SIGKILL) || err != nil {
+		t.Errorf("killed process = %d, %v, expected 137", code, err)
+	}
+
+	if code, err := waitExitCode(errors.New("start failed")); code != -1 || err == nil {
+		t.Errorf("non exit error = %d, %v, expected -1 and the error", code, err)
+	}
+}
+
+func TestExecEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "A=1"}
+	info := &ContainerInfo{Pid: "1", Config: &InitConfig{Env: env, Init: true}}
+	if envs := execEnv(info); !reflect.DeepEqual(envs, env) {
+		t.Errorf("execEnv = %q, expected the recorded env %q", envs, env)
+	}
+
+	// 没有记录配置的旧容器从 init 进程的 /proc 读取
+	cmd := exec.Command("sleep", "60")
+	cmd.Env = []string{"FROM_PROC=1"}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	info = &ContainerInfo{Pid: strconv.Itoa(cmd.Process.Pid)}
+	if envs := execEnv(info); !reflect.DeepEqual(envs, cmd.Env) {
+		t.Errorf("execEnv of old container = %q, expected %q", envs, cmd.Env)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+	记录容器 init 进程的退出状态，只有 init 进程的父进程才能拿到:
+	退出码，被信号杀死时为 128+信号，同时记录信号名
+	退出时间，ps 中显示为 Exited (137) 5 minutes ago
+	是否因为超出内存限制被 OOM killer 杀死，需要在删除 cgroup 之前检查
+*/
+
+package container
+
+import (
+	"docker-go/cgroups/subsystem"
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+	"time"
+)
+
+// 容器信息中时间的格式
+const timeFormat = "2006-01-02 15:04:05"
+
+// RecordContainerExit 容器的 init 进程退出后记录退出状态，state 为 wait 的结果
+// 数据卷和工作空间由调用者清理
+func RecordContainerExit(containerName string, state *os.ProcessState) error {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	info.ExitCode, info.ExitSignal = exitStatus(state)
+	info.FinishedAt = time.Now().Format(timeFormat)
+	if info.OOMKilled, err = subsystem.OOMKilled(CgroupPath(containerName)); err != nil {
+		logrus.Errorf("check oom of %s, err: %v", containerName, err)
+	}
+	info.Status = common.Exit
+	info.Pid = ""
+	info.ShimPid = ""
+
+	return saveContainerInfo(info)
+}
+
+// 获取退出码，被信号杀死时同时返回信号名
+func exitStatus(state *os.ProcessState) (int, string) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return state.ExitCode(), ""
+	}
+	if status.Signaled() {
+		return exitCodeOf(status), unix.SignalName(status.Signal())
+	}
+
+	return exitCodeOf(status), ""
+}
+
+// ps 中显示的容器状态
+func displayStatus(info *ContainerInfo, now time.Time) string {
+	if info.Status != common.Exit {
+		return info.Status
+	}
+	status := fmt.Sprintf("Exited (%d)", info.ExitCode)
+	if info.OOMKilled {
+		status += " (OOMKilled)"
+	}
+	finishedAt, err := time.ParseInLocation(timeFormat, info.FinishedAt, time.Local)
+	if err != nil {
+		return status
+	}
+
+	return fmt.Sprintf("%s %s ago", status, humanDuration(now.Sub(finishedAt)))
+}
+
+// 将时间间隔转换为便于阅读的形式，如 5 minutes
+func humanDuration(d time.Duration) string {
+	if seconds := int(d.Seconds()); seconds < 1 {
+		return "Less than a second"
+	} else if seconds == 1 {
+		return "1 second"
+	} else if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes == 1 {
+		return "About a minute"
+	} else if minutes < 60 {
+		return fmt.Sprintf("%d minutes", minutes)
+	} else if hours := int(d.Hours() + 0.5); hours == 1 {
+		return "About an hour"
+	} else if hours < 48 {
+		return fmt.Sprintf("%d hours", hours)
+	} else if hours < 24*7*2 {
+		return fmt.Sprintf("%d days", hours/24)
+	} else if hours < 24*30*2 {
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	} else if hours < 24*365*2 {
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
@@ -0,0 +1,62 @@
+package container
+
+import (
+	"docker-go/common"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+	_ = cmd.Run()
+	if code, signal := exitStatus(cmd.ProcessState); code != 3 || signal != "" {
+		t.Errorf("exitStatus = %d %q, expected 3", code, signal)
+	}
+
+	cmd = exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	if code, signal := exitStatus(cmd.ProcessState); code != 137 || signal != "SIGKILL" {
+		t.Errorf("exitStatus = %d %q, expected 137 SIGKILL", code, signal)
+	}
+}
+
+func TestDisplayStatus(t *testing.T) {
+	now := time.Now()
+	info := &ContainerInfo{
+		Status:     common.Exit,
+		ExitCode:   137,
+		FinishedAt: now.Add(-5 * time.Minute).Format(timeFormat),
+	}
+	if status := displayStatus(info, now); status != "Exited (137) 5 minutes ago" {
+		t.Errorf("displayStatus = %q", status)
+	}
+	info.OOMKilled = true
+	if status := displayStatus(info, now); status != "Exited (137) (OOMKilled) 5 minutes ago" {
+		t.Errorf("displayStatus = %q", status)
+	}
+	info.Status = common.Running
+	if status := displayStatus(info, now); status != common.Running {
+		t.Errorf("displayStatus = %q", status)
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	cases := map[time.Duration]string{
+		500 * time.Millisecond: "Less than a second",
+		30 * time.Second:       "30 seconds",
+		90 * time.Second:       "About a minute",
+		45 * time.Minute:       "45 minutes",
+		3 * time.Hour:          "3 hours",
+		72 * time.Hour:         "3 days",
+	}
+	for d, expected := range cases {
+		if s := humanDuration(d); s != expected {
+			t.Errorf("humanDuration(%v) = %q, expected %q", d, s, expected)
+		}
+	}
+}
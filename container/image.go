@@ -0,0 +1,233 @@
+/*
+	本地镜像清单，保存在 /root/images/镜像名/manifest.json
+	清单中记录镜像每一层的 sha256 摘要，镜像签名针对清单的内容
+	由 /root/镜像名.tar 导入的镜像只有一层，即该 tar 包本身
+	commit、squash 生成的镜像层保存在 /root/imageLayers/摘要.tar，多个镜像可共用同一层
+*/
+
+package container
+
+import (
+	"crypto/sha256"
+	"docker-go/common"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// /root 下 docker-go 使用的其它目录，不能作为镜像名
+var reservedImageNames = []string{
+	path.Base(common.ImageStorePath),
+	path.Base(common.ImageLayerPath),
+	path.Base(common.MntPath),
+	common.WriteLayer,
+}
+
+// ImageManifest 镜像清单
+type ImageManifest struct {
+	Name    string        `json:"name"`
+	Layers  []*ImageLayer `json:"layers"` // 镜像层，从下到上排列
+	Created string        `json:"created"`
+
+	StopSignal string `json:"stopSignal,omitempty"` // 停止容器时发送的信号，为空时为 SIGTERM
+}
+
+// ImageLayer 镜像层
+type ImageLayer struct {
+	Digest string `json:"digest"` // sha256:十六进制摘要
+	Size   int64  `json:"size"`
+}
+
+// 镜像 tar 包路径
+func imageTarPath(imageName string) string {
+	return path.Join(common.RootPath, fmt.Sprintf("%s.tar", imageName))
+}
+
+// 镜像清单路径
+func imageManifestPath(imageName string) string {
+	return path.Join(common.ImageStorePath, imageName, common.ImageManifestFileName)
+}
+
+// 读取镜像清单，同时返回清单文件的原始内容
+func loadImageManifest(imageName string) (*ImageManifest, []byte, error) {
+	bs, err := ioutil.ReadFile(imageManifestPath(imageName))
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := &ImageManifest{}
+	if err = json.Unmarshal(bs, manifest); err != nil {
+		return nil, nil, err
+	}
+
+	return manifest, bs, nil
+}
+
+// 根据镜像 tar 包生成并保存镜像清单
+func createImageManifest(imageName string) (*ImageManifest, []byte, error) {
+	digest, size, err := fileDigest(imageTarPath(imageName))
+	if err != nil {
+		logrus.Errorf("digest image tar, image: %s, err: %v", imageName, err)
+		return nil, nil, err
+	}
+	manifest := &ImageManifest{
+		Name:    imageName,
+		Layers:  []*ImageLayer{{Digest: digest, Size: size}},
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	bs, err := saveImageManifest(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return manifest, bs, nil
+}
+
+// 保存镜像清单，返回写入的内容
+func saveImageManifest(manifest *ImageManifest) ([]byte, error) {
+	dir := path.Join(common.ImageStorePath, manifest.Name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logrus.Errorf("mkdir image store dir: %s, err: %v", dir, err)
+		return nil, err
+	}
+	bs, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(imageManifestPath(manifest.Name), bs, 0644); err != nil {
+		logrus.Errorf("write image manifest, image: %s, err: %v", manifest.Name, err)
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+// 获取镜像清单，由 tar 包导入的镜像没有清单时自动生成
+func getImageManifest(imageName string) (*ImageManifest, error) {
+	manifest, _, err := loadImageManifest(imageName)
+	if err == nil {
+		return manifest, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if _, err = os.Stat(imageTarPath(imageName)); err != nil {
+		return nil, fmt.Errorf("image %s not found", imageName)
+	}
+	manifest, _, err = createImageManifest(imageName)
+
+	return manifest, err
+}
+
+// 检查新镜像的名字，镜像名会作为 /root 下的目录名和文件名
+// 只拒绝不能作为单个文件名或会与 docker-go 自己的目录冲突的名字，其它名字与之前一样可以使用
+func validateImageName(imageName string) error {
+	if imageName == "" || imageName == "." || imageName == ".." || strings.ContainsAny(imageName, "/\x00") {
+		return fmt.Errorf("invalid image name %q, it must be a single file name", imageName)
+	}
+	for _, reserved := range reservedImageNames {
+		if imageName == reserved {
+			return fmt.Errorf("image name %s is reserved", imageName)
+		}
+	}
+
+	return nil
+}
+
+// 镜像是否已存在
+func imageExists(imageName string) bool {
+	if _, err := os.Stat(imageTarPath(imageName)); err == nil {
+		return true
+	}
+	_, err := os.Stat(imageManifestPath(imageName))
+	return err == nil
+}
+
+// 删除镜像清单和签名
+func removeImageManifest(imageName string) error {
+	return os.RemoveAll(path.Join(common.ImageStorePath, imageName))
+}
+
+// 获取所有镜像清单
+func listImageManifests() []*ImageManifest {
+	files, err := ioutil.ReadDir(common.ImageStorePath)
+	if err != nil {
+		return nil
+	}
+	var manifests []*ImageManifest
+	for _, file := range files {
+		manifest, _, err := loadImageManifest(file.Name())
+		if err != nil {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	return manifests
+}
+
+// legacy 镜像只有一层，且该层就是 /root/镜像名.tar
+func (m *ImageManifest) legacy() bool {
+	if len(m.Layers) != 1 {
+		return false
+	}
+	_, err := os.Stat(imageTarPath(m.Name))
+	return err == nil
+}
+
+// 镜像层对应的文件
+func layerBlobPath(manifest *ImageManifest, layer *ImageLayer) string {
+	if manifest.legacy() {
+		return imageTarPath(manifest.Name)
+	}
+	return layerStorePath(layer.Digest)
+}
+
+// 镜像层在层存储中的路径
+func layerStorePath(digest string) string {
+	return path.Join(common.ImageLayerPath, fmt.Sprintf("%s.tar", strings.TrimPrefix(digest, "sha256:")))
+}
+
+// 校验镜像层的内容与清单中记录的摘要一致
+func verifyImageLayers(manifest *ImageManifest) error {
+	for _, layer := range manifest.Layers {
+		digest, _, err := fileDigest(layerBlobPath(manifest, layer))
+		if err != nil {
+			return err
+		}
+		if digest != layer.Digest {
+			return fmt.Errorf("image %s layer digest mismatch, expected %s, got %s", manifest.Name, layer.Digest, digest)
+		}
+	}
+
+	return nil
+}
+
+// 计算文件的 sha256 摘要
+func fileDigest(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// 计算镜像清单的 sha256 摘要
+func manifestDigest(bs []byte) string {
+	sum := sha256.Sum256(bs)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,25 @@
+package container
+
+import "testing"
+
+func TestValidateImageName(t *testing.T) {
+	valid := []string{
+		"busybox", "my-app_1.0", "Ubuntu", "busybox-squashed", "mntx", "images2",
+		"busybox:latest", "bus box", "-busybox", ".hidden", "...",
+	}
+	for _, name := range valid {
+		if err := validateImageName(name); err != nil {
+			t.Errorf("validateImageName(%s), err: %v", name, err)
+		}
+	}
+
+	invalid := []string{
+		"", ".", "..", "../etc", "a/b", "/root", "bad\x00name",
+		"images", "imageLayers", "mnt", "writeLayer",
+	}
+	for _, name := range invalid {
+		if err := validateImageName(name); err == nil {
+			t.Errorf("validateImageName(%q) expected error", name)
+		}
+	}
+}
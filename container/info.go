@@ -1,6 +1,7 @@
 package container
 
 import (
+	"docker-go/cgroups/subsystem"
 	"docker-go/common"
 	"encoding/json"
 	"fmt"
@@ -9,7 +10,6 @@ import (
 	"math/rand"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -17,29 +17,52 @@ import (
 
 // ContainerInfo 容器信息
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`     // 容器的init进程在宿主机上的PID
-	Id          string   `json:"id"`      // 容器ID
-	Command     string   `json:"command"` // 容器内init进程运行的命令
-	Name        string   `json:"name"`
-	CreateTime  string   `json:"createTime"`
-	Status      string   `json:"status"`
-	Volume      string   `json:"volume"`      // 容器的数据卷
-	PortMapping []string `json:"portmapping"` // 端口映射
+	Pid         string      `json:"pid"`     // 容器的init进程在宿主机上的PID
+	ShimPid     string      `json:"shimPid"` // 后台容器的 shim 进程的PID
+	Id          string      `json:"id"`      // 容器ID
+	Command     string      `json:"command"` // 容器内init进程运行的命令
+	Name        string      `json:"name"`
+	CreateTime  string      `json:"createTime"`
+	Status      string      `json:"status"`
+	Image       string      `json:"image"`       // 容器使用的镜像名
+	StorageSize int64       `json:"storageSize"` // 读写层的大小上限，0 表示不限制
+	Config      *InitConfig `json:"config"`      // 发送给 init 进程的配置，数据卷、终端等都以此为准
+	PortMapping []string    `json:"portmapping"` // 端口映射
+
+	Resources  *subsystem.ResourceConfig `json:"resources"`  // 资源限制，start 时重新设置
+	StopSignal string                    `json:"stopSignal"` // stop 时发送的信号
+
+	// 容器最近一次退出的状态
+	ExitCode   int    `json:"exitCode"`             // 退出码，被信号杀死时为 128+信号
+	ExitSignal string `json:"exitSignal,omitempty"` // 杀死 init 进程的信号
+	FinishedAt string `json:"finishedAt,omitempty"` // 退出时间
+	OOMKilled  bool   `json:"oomKilled"`            // 是否因为超出内存限制被杀死
+}
+
+// inspect 输出的容器信息
+type containerInspect struct {
+	*ContainerInfo
+	StorageUsage int64 `json:"storageUsage"` // 读写层占用的空间
 }
 
 // RecordContainerInfo 记录容器信息
 // 1. 创建以容器名或 ID 命名的文件夹
 // 2. 在该文件下创建 config.json
 // 3. 将容器信息保存到 config.json 中
-func RecordContainerInfo(containerPID int, cmdArray []string, containerName, containerID string) error {
+// 记录时容器还没有启动，init 进程启动后由 SetContainerRunning 记录
+func RecordContainerInfo(containerName, containerID, imageName string, config *InitConfig, res *subsystem.ResourceConfig, storageSize int64, stopSignal string) error {
 	// 生成容器基础信息
 	info := &ContainerInfo{
-		Pid:        strconv.Itoa(containerPID),
-		Id:         containerID,
-		Command:    strings.Join(cmdArray, " "),
-		Name:       containerName,
-		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
-		Status:     common.Running,
+		Id:          containerID,
+		Command:     strings.Join(config.Args, " "),
+		Name:        containerName,
+		CreateTime:  time.Now().Format(timeFormat),
+		Status:      common.Created,
+		Image:       imageName,
+		StorageSize: storageSize,
+		Config:      config,
+		Resources:   res,
+		StopSignal:  stopSignal,
 	}
 	// 创建容器目录
 	dir := path.Join(common.DefaultContainerInfoPath, containerName)
@@ -96,13 +119,36 @@ func DeleteContainerInfo(containerName string) {
 // 2. 读取每个容器内的 config.json 文件
 // 3. 格式化打印
 func ListContainerInfo() {
+	infos := listContainerInfos()
+	for _, info := range infos {
+		refreshContainerStatus(info)
+	}
+
+	// 3. 格式化打印
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 2, ' ', 0)
+	_, _ = fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	now := time.Now()
+	for _, info := range infos {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", info.Id, info.Name, info.Pid, displayStatus(info, now), info.Command, info.CreateTime)
+	}
+	// 刷新标准输出流缓存区，将容器列表打印出来
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush info, err:%v", err)
+	}
+}
+
+// 获取所有容器的信息
+func listContainerInfos() []*ContainerInfo {
 	files, err := ioutil.ReadDir(common.DefaultContainerInfoPath)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		logrus.Errorf("read info dir, err: %v", err)
 	}
 	var infos []*ContainerInfo
 	// 1. 遍历 docker-go 文件夹
 	for _, file := range files {
+		if isReservedStateDir(file.Name()) {
+			continue
+		}
 		// 2. 读取每个容器内的 config.json 文件
 		info, err := getContainerInfo(file.Name())
 		if err != nil {
@@ -112,16 +158,40 @@ func ListContainerInfo() {
 		infos = append(infos, info)
 	}
 
-	// 3. 格式化打印
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 2, ' ', 0)
-	_, _ = fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
-	for _, info := range infos {
-		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", info.Id, info.Name, info.Pid, info.Status, info.Command, info.CreateTime)
+	return infos
+}
+
+// InspectContainer 以 json 格式打印容器的详细信息
+func InspectContainer(containerName string) error {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return err
 	}
-	// 刷新标准输出流缓存区，将容器列表打印出来
-	if err := w.Flush(); err != nil {
-		logrus.Errorf("flush info, err:%v", err)
+	refreshContainerStatus(info)
+	// 附带读写层当前占用的空间
+	inspect := &containerInspect{
+		ContainerInfo: info,
+		StorageUsage:  dirSize(path.Join(common.RootPath, common.WriteLayer, containerName)),
+	}
+	bs, err := json.MarshalIndent(inspect, "", "    ")
+	if err != nil {
+		return err
 	}
+	_, _ = fmt.Fprintln(os.Stdout, string(bs))
+
+	return nil
+}
+
+// 网络配置和数据卷插件也保存在容器信息目录下，这些目录不属于容器
+func isReservedStateDir(name string) bool {
+	stateDir := path.Join(common.DefaultContainerInfoPath, name)
+	for _, reserved := range []string{common.DefaultNetworkPath, common.DefaultPluginPath} {
+		if strings.HasPrefix(reserved, stateDir+"/") {
+			return true
+		}
+	}
+
+	return false
 }
 
 // 获取容器详细信息
@@ -137,3 +207,12 @@ func getContainerInfo(containerName string) (*ContainerInfo, error) {
 
 	return info, err
 }
+
+// 容器的数据卷，没有记录配置的旧容器返回 nil
+func (c *ContainerInfo) volumes() []*Volume {
+	if c.Config == nil {
+		return nil
+	}
+
+	return c.Config.Volumes
+}
@@ -8,11 +8,8 @@ package container
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
@@ -21,56 +18,97 @@ import (
 // 使用mount挂载proc文件系统
 // 以便后面通过 ps 等系统命令查看当前进程资源的情况
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
-		return fmt.Errorf("get user command in run container")
+	// 指 index 为 3 的文件描述符，也就是 cmd.ExtraFiles 中 我们传递过来的 readPipe
+	config, err := readInitConfig(os.NewFile(uintptr(3), "pipe"))
+	if err != nil {
+		logrus.Errorf("read init config, err: %v", err)
+		return err
+	}
+	// 主机名只在容器的 uts namespace 中生效
+	if config.Hostname != "" {
+		if err = syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			logrus.Errorf("set hostname, err: %v", err)
+			return err
+		}
 	}
 	// 挂载
-	err := setUpMount()
+	err = setUpMount(config)
 	if err != nil {
 		logrus.Errorf("set up mount, err：%v", err)
 		return err
 	}
-
-	// 在系统环境 PATH 中寻找命令的绝对路径
-	path, err := exec.LookPath(cmdArray[0])
+	// 分配终端
+	if config.Tty {
+		if err = setUpConsole(); err != nil {
+			logrus.Errorf("set up console, err: %v", err)
+			return err
+		}
+	}
+	if err = syscall.Chdir(config.Cwd); err != nil {
+		logrus.Errorf("chdir to %s, err: %v", config.Cwd, err)
+		return err
+	}
+	if err = setRlimits(config.Rlimits); err != nil {
+		logrus.Errorf("set rlimits, err: %v", err)
+		return err
+	}
+	env, err := setUser(config.User, config.Env)
 	if err != nil {
-		logrus.Errorf("look %s path, err: %v", cmdArray[0], err)
+		logrus.Errorf("set user, err: %v", err)
 		return err
 	}
 
-	err = syscall.Exec(path, cmdArray[0:], os.Environ())
+	// 在容器环境变量的 PATH 中寻找命令的绝对路径
+	path, err := lookPath(config.Args[0], env)
 	if err != nil {
+		logrus.Errorf("look %s path, err: %v", config.Args[0], err)
 		return err
 	}
 
-	return nil
-}
+	// --init 时保留 docker-go init 作为 1 号进程
+	if config.Init {
+		return runAsInit(path, config.Args, env, config.Tty)
+	}
 
-func readUserCommand() []string {
-	// 指 index 为 3 的文件描述符
-	// 也就是 cmd.ExtraFiles 中 我们传递过来的 readPipe
-	pipe := os.NewFile(uintptr(3), "pipe")
-	bs, err := ioutil.ReadAll(pipe)
+	err = syscall.Exec(path, config.Args, env)
 	if err != nil {
-		logrus.Errorf("read pipe, err： %v", err)
-		return nil
+		return err
 	}
-	msg := string(bs)
 
-	return strings.Split(msg, " ")
+	return nil
 }
 
-func setUpMount() error {
-	err := pivotRoot()
+func setUpMount(config *InitConfig) error {
+	root, err := os.Getwd()
 	if err != nil {
-		logrus.Errorf("pivot root, err: %v", err)
 		return err
 	}
+	logrus.Infof("current location is %s", root)
 	// systemd 加入linux之后， mount namespace 就变成 shared by default, 所以必须显示
-	// 声明你要这个新的mount namespace 独立
-	err = syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
+	// 声明你要这个新的mount namespace 独立，这里使用 rslave，容器中的挂载不会传播到宿主机
+	err = syscall.Mount("", "/", "", rootPropagation, "")
+	if err != nil {
+		return err
+	}
+	if err = makeParentMountPrivate(root); err != nil {
+		return fmt.Errorf("make parent mount of rootfs private: %v", err)
+	}
+
+	// 为了使当前root的老 root 和新 root 不在同一个文件系统下，我们把root重新mount了一次
+	// bind mount是把相同的内容换了一个挂载点的挂载方法
+	if err = syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("mount rootfs to itself error: %v", err)
+	}
+
+	// 挂载数据卷，pivot_root 之后就访问不到宿主机上的路径了
+	if err = bindVolumes(root, config.Volumes); err != nil {
+		logrus.Errorf("bind volumes, err: %v", err)
+		return err
+	}
+
+	err = pivotRoot(root)
 	if err != nil {
+		logrus.Errorf("pivot root, err: %v", err)
 		return err
 	}
 
@@ -88,34 +126,54 @@ func setUpMount() error {
 		logrus.Errorf("mount tempfs, err: %v", err)
 		return err
 	}
+	// 创建设备节点，挂载 devpts 和 shm
+	if err = setUpDev(config.Devices); err != nil {
+		logrus.Errorf("set up /dev, err: %v", err)
+		return err
+	}
 
-	return nil
-}
+	// 只读的 sysfs，屏蔽敏感路径，内核配置只读
+	if err = mountSysfs(); err != nil {
+		logrus.Errorf("mount sysfs, err: %v", err)
+		return err
+	}
+	if err = maskKernelPaths(); err != nil {
+		logrus.Errorf("mask kernel paths, err: %v", err)
+		return err
+	}
+	if err = readonlyKernelPaths(); err != nil {
+		logrus.Errorf("readonly kernel paths, err: %v", err)
+		return err
+	}
 
-// 改变当前root文件系统
-func pivotRoot() error {
-	root, err := os.Getwd()
-	if err != nil {
+	// 挂载 --tmpfs 指定的 tmpfs
+	if err = mountTmpfs(config.Tmpfs); err != nil {
+		logrus.Errorf("mount tmpfs, err: %v", err)
 		return err
 	}
-	logrus.Infof("current location is %s", root)
 
-	// systemd 加入linux之后, mount namespace 就变成 shared by default, 所以你必须显示
-	//声明你要这个新的mount namespace独立
-	err = syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
-	if err != nil {
+	// 工作目录不存在时创建，必须在根目录设为只读之前
+	if err = os.MkdirAll(config.Cwd, 0755); err != nil {
+		logrus.Errorf("mkdir working directory %s, err: %v", config.Cwd, err)
 		return err
 	}
 
-	// 为了使当前root的老 root 和新 root 不在同一个文件系统下，我们把root重新mount了一次
-	// bind mount是把相同的内容换了一个挂载点的挂载方法
-	if err = syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("mount rootfs to itself error: %v", err)
+	// 只读根目录，只影响根目录本身的挂载，数据卷、tmpfs、/proc、/dev 仍然可写
+	if config.ReadOnly {
+		if err = syscall.Mount("", "/", "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			logrus.Errorf("remount root read only, err: %v", err)
+			return err
+		}
 	}
 
+	return nil
+}
+
+// 改变当前root文件系统，root 必须是一个挂载点
+func pivotRoot(root string) error {
 	// 创建rootfs/.pivot_root 存储 old_root
 	pivotDir := filepath.Join(root, ".pivot_root")
-	_, err = os.Stat(pivotDir)
+	_, err := os.Stat(pivotDir)
 	if err != nil && os.IsNotExist(err) {
 		if err = os.Mkdir(pivotDir, 0777); err != nil {
 			return err
@@ -134,6 +192,10 @@ func pivotRoot() error {
 	}
 
 	pivotDir = filepath.Join("/", ".pivot_root")
+	// 老的 root 可能是 shared 的，先设为 rslave，避免下面的卸载传播到宿主机
+	if err = syscall.Mount("", pivotDir, "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make pivot_root dir rslave %v", err)
+	}
 	// umount rootfs/.pivot_root
 	if err = syscall.Unmount(pivotDir, syscall.MNT_DETACH); err != nil {
 		return fmt.Errorf("unmount pivot_root dir %v", err)
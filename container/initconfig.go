@@ -0,0 +1,190 @@
+/*
+	docker-go 与容器 init 进程之间通过管道(fd 3)传递一个 json 格式的 InitConfig
+	容器的命令、环境变量、工作目录、用户、主机名、挂载和资源上限都在其中
+	init 进程读到 EOF 后解析，按顺序完成: 主机名 -> 挂载 -> 终端 -> 工作目录 -> rlimit -> 用户 -> exec
+	Init 为 true 时最后一步不 exec，init 进程启动用户命令后作为 1 号进程继续运行
+*/
+
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 容器默认的工作目录
+const defaultWorkingDir = "/"
+
+// InitConfig 容器 init 进程的配置
+type InitConfig struct {
+	Args     []string      `json:"args"`               // 用户命令，Args[0] 为命令本身
+	Env      []string      `json:"env"`                // 用户命令的环境变量
+	Cwd      string        `json:"cwd"`                // 工作目录，不存在时创建
+	User     string        `json:"user,omitempty"`     // 用户名或 uid[:gid]，为空时为 root
+	Hostname string        `json:"hostname,omitempty"` // 主机名
+	Tty      bool          `json:"tty"`                // 是否分配终端
+	ReadOnly bool          `json:"readOnly"`           // 根目录是否只读
+	Volumes  []*Volume     `json:"volumes,omitempty"`  // 数据卷
+	Tmpfs    []*TmpfsMount `json:"tmpfs,omitempty"`    // tmpfs 挂载
+	Devices  []*Device     `json:"devices,omitempty"`  // --device 添加的设备
+	Rlimits  []*Rlimit     `json:"rlimits,omitempty"`  // 资源上限
+	Init     bool          `json:"init,omitempty"`     // 是否由 docker-go init 作为 1 号进程转发信号、回收僵尸进程
+}
+
+// Rlimit 资源上限，对应 setrlimit
+type Rlimit struct {
+	Name string `json:"name"` // 去掉 RLIMIT_ 前缀的小写名字，如 nofile
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// 支持的资源上限
+var rlimitTypes = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// ParseEnv 解析 -e 参数，格式为 KEY=VALUE，只有 KEY 时使用宿主机上同名变量的值，宿主机上没有时忽略
+// 宿主机的其它环境变量不会传入容器，没有设置 PATH 时使用默认值，同名变量以最后一个为准
+func ParseEnv(specs []string) ([]string, error) {
+	env := []string{"PATH=" + defaultPathEnv}
+	index := map[string]int{"PATH": 0}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if kv[0] == "" || strings.ContainsAny(kv[0], " \t\n\x00") {
+			return nil, fmt.Errorf("invalid env %q, expected KEY=VALUE", spec)
+		}
+		if len(kv) == 1 {
+			value, ok := os.LookupEnv(kv[0])
+			if !ok {
+				continue
+			}
+			spec = kv[0] + "=" + value
+		}
+		if i, ok := index[kv[0]]; ok {
+			env[i] = spec
+			continue
+		}
+		index[kv[0]] = len(env)
+		env = append(env, spec)
+	}
+
+	return env, nil
+}
+
+// ParseUlimits 解析 --ulimit 参数，格式为 名字=软上限[:硬上限]，如 nofile=1024:2048，-1 表示不限制
+func ParseUlimits(specs []string) ([]*Rlimit, error) {
+	var rlimits []*Rlimit
+	names := make(map[string]bool)
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid ulimit %q, expected name=soft[:hard]", spec)
+		}
+		name := strings.ToLower(kv[0])
+		if _, ok := rlimitTypes[name]; !ok {
+			return nil, fmt.Errorf("invalid ulimit %q: unknown type %s", spec, kv[0])
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate ulimit %s", name)
+		}
+		names[name] = true
+		values := strings.SplitN(kv[1], ":", 2)
+		soft, err := parseRlimitValue(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %q: %v", spec, err)
+		}
+		hard := soft
+		if len(values) == 2 {
+			if hard, err = parseRlimitValue(values[1]); err != nil {
+				return nil, fmt.Errorf("invalid ulimit %q: %v", spec, err)
+			}
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("invalid ulimit %q: soft limit is greater than hard limit", spec)
+		}
+		rlimits = append(rlimits, &Rlimit{Name: name, Soft: soft, Hard: hard})
+	}
+
+	return rlimits, nil
+}
+
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "-1" || value == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+
+	return n, nil
+}
+
+// SendInitConfig 将 InitConfig 写入管道并关闭，init 进程读到 EOF 后开始初始化
+func SendInitConfig(config *InitConfig, writePipe *os.File) error {
+	defer writePipe.Close()
+	bs, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = writePipe.Write(bs)
+
+	return err
+}
+
+// 从管道读取 InitConfig
+func readInitConfig(pipe *os.File) (*InitConfig, error) {
+	defer pipe.Close()
+	bs, err := ioutil.ReadAll(pipe)
+	if err != nil {
+		return nil, fmt.Errorf("read init pipe: %v", err)
+	}
+	config := &InitConfig{}
+	if err = json.Unmarshal(bs, config); err != nil {
+		return nil, fmt.Errorf("parse init config: %v", err)
+	}
+	if len(config.Args) == 0 {
+		return nil, fmt.Errorf("empty user command")
+	}
+	if config.Cwd == "" {
+		config.Cwd = defaultWorkingDir
+	}
+	if !path.IsAbs(config.Cwd) {
+		return nil, fmt.Errorf("working directory %s is not absolute", config.Cwd)
+	}
+
+	return config, nil
+}
+
+// 设置资源上限
+func setRlimits(rlimits []*Rlimit) error {
+	for _, rlimit := range rlimits {
+		limit := &unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}
+		if err := unix.Setrlimit(rlimitTypes[rlimit.Name], limit); err != nil {
+			return fmt.Errorf("set rlimit %s: %v", rlimit.Name, err)
+		}
+	}
+
+	return nil
+}
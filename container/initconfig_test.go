@@ -0,0 +1,94 @@
+package container
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseUlimits(t *testing.T) {
+	rlimits, err := ParseUlimits([]string{"nofile=1024:2048", "core=0", "NPROC=-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Rlimit{
+		{Name: "nofile", Soft: 1024, Hard: 2048},
+		{Name: "core", Soft: 0, Hard: 0},
+		{Name: "nproc", Soft: unix.RLIM_INFINITY, Hard: unix.RLIM_INFINITY},
+	}
+	if !reflect.DeepEqual(rlimits, expected) {
+		t.Errorf("rlimits = %+v, expected %+v", rlimits, expected)
+	}
+
+	invalid := [][]string{
+		{"nofile"},
+		{"files=10"},
+		{"nofile=abc"},
+		{"nofile=2048:1024"},
+		{"nofile=1", "nofile=2"},
+	}
+	for _, specs := range invalid {
+		if _, err := ParseUlimits(specs); err == nil {
+			t.Errorf("ParseUlimits(%q) expected error", specs)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	_ = os.Setenv("DOCKER_GO_TEST_HOST", "host")
+	defer os.Unsetenv("DOCKER_GO_TEST_HOST")
+	_ = os.Unsetenv("DOCKER_GO_TEST_UNSET")
+
+	tests := []struct {
+		specs    []string
+		expected []string
+	}{
+		{nil, []string{"PATH=" + defaultPathEnv}},
+		{[]string{"A=1", "B="}, []string{"PATH=" + defaultPathEnv, "A=1", "B="}},
+		{[]string{"PATH=/bin", "A=1=2"}, []string{"PATH=/bin", "A=1=2"}},
+		{[]string{"A=1", "B=2", "A=3"}, []string{"PATH=" + defaultPathEnv, "A=3", "B=2"}},
+		{[]string{"DOCKER_GO_TEST_HOST", "DOCKER_GO_TEST_UNSET"}, []string{"PATH=" + defaultPathEnv, "DOCKER_GO_TEST_HOST=host"}},
+	}
+	for _, test := range tests {
+		env, err := ParseEnv(test.specs)
+		if err != nil {
+			t.Errorf("ParseEnv(%q), err: %v", test.specs, err)
+			continue
+		}
+		if !reflect.DeepEqual(env, test.expected) {
+			t.Errorf("ParseEnv(%q) = %q, expected %q", test.specs, env, test.expected)
+		}
+	}
+	// 宿主机的环境变量不能泄露到容器中
+	env, _ := ParseEnv(nil)
+	for _, e := range env {
+		if e == "DOCKER_GO_TEST_HOST=host" {
+			t.Errorf("host env leaked into container env %q", env)
+		}
+	}
+
+	for _, spec := range []string{"=1", "A B=1", ""} {
+		if _, err := ParseEnv([]string{spec}); err == nil {
+			t.Errorf("ParseEnv(%q) expected error", spec)
+		}
+	}
+}
+
+func TestSendInitConfig(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &InitConfig{Args: []string{"sh", "-c", "echo \"a b\""}, Cwd: "/work", User: "1000:1000"}
+	if err = SendInitConfig(config, w); err != nil {
+		t.Fatal(err)
+	}
+	received, err := readInitConfig(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(received, config) {
+		t.Errorf("received %+v, expected %+v", received, config)
+	}
+}
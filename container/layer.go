@@ -0,0 +1,321 @@
+/*
+	镜像层的打包与解压
+	镜像层是一个 tar 包，使用 aufs 的白障文件记录删除:
+	.wh.name 表示删除下层的 name，.wh..wh..opq 表示目录中下层的内容全部不可见
+	按顺序将各层解压到同一个目录中，并处理白障文件，即可得到镜像完整的文件系统
+*/
+
+package container
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"docker-go/common"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 将读写层目录打包为镜像层，保存到层存储中
+func saveLayerDir(dir string) (*ImageLayer, error) {
+	if err := os.MkdirAll(common.ImageLayerPath, os.ModePerm); err != nil {
+		logrus.Errorf("mkdir layer store, err: %v", err)
+		return nil, err
+	}
+	file, err := ioutil.TempFile(common.ImageLayerPath, "layer-")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	hash := sha256.New()
+	counter := &countWriter{}
+	err = writeLayerTar(dir, io.MultiWriter(file, hash, counter))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logrus.Errorf("write layer tar, dir: %s, err: %v", dir, err)
+		return nil, err
+	}
+
+	layer := &ImageLayer{
+		Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:   counter.n,
+	}
+	if err = os.Rename(tmpPath, layerStorePath(layer.Digest)); err != nil {
+		return nil, err
+	}
+
+	return layer, nil
+}
+
+// 确保镜像层保存在层存储中，tar 包导入的镜像通过硬链接加入层存储
+func ensureLayerBlob(manifest *ImageManifest, layer *ImageLayer) error {
+	blobPath := layerStorePath(layer.Digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(common.ImageLayerPath, os.ModePerm); err != nil {
+		return err
+	}
+	src := layerBlobPath(manifest, layer)
+	if err := os.Link(src, blobPath); err == nil {
+		return nil
+	}
+
+	// 不在同一个文件系统时复制
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(blobPath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(blobPath)
+		return err
+	}
+
+	return out.Close()
+}
+
+// 将目录打包为镜像层 tar，aufs 内部文件不打包，overlay 的白障转换为 aufs 格式
+func writeLayerTar(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := path.Base(rel)
+		if strings.HasPrefix(name, whiteoutMetaPrefix) && name != whiteoutOpaqueDir {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isOverlayWhiteout(fi) {
+			return writeWhiteout(tw, path.Join(path.Dir(rel), whiteoutPrefix+name))
+		}
+		if err = writeTarFile(tw, filePath, fi, rel); err != nil {
+			return err
+		}
+		if fi.IsDir() && isOverlayOpaque(filePath) {
+			return writeWhiteout(tw, path.Join(rel, whiteoutOpaqueDir))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func writeWhiteout(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0444,
+	})
+}
+
+// 解压镜像时使用的层文件，以及该层应有的摘要
+type layerSource struct {
+	blobPath string
+	digest   string
+}
+
+// 将镜像的所有层依次解压到 dir 中
+func applyImageLayers(dir string, sources []*layerSource) error {
+	for _, source := range sources {
+		if err := applyVerifiedLayer(dir, source); err != nil {
+			logrus.Errorf("apply layer %s, err: %v", source.digest, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 解压镜像层，同时计算读到的内容的摘要，与应有的摘要不一致时返回错误
+// 校验的和解压的是同一份内容，不会在校验之后被替换
+func applyVerifiedLayer(dir string, source *layerSource) error {
+	file, err := os.Open(source.blobPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	r := io.TeeReader(file, hash)
+	if err = applyLayerReader(dir, r, false); err != nil {
+		return err
+	}
+	// tar 包的结束标记之后可能还有填充的内容
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != source.digest {
+		return fmt.Errorf("layer %s digest mismatch, expected %s, got %s", source.blobPath, source.digest, digest)
+	}
+
+	return nil
+}
+
+// 将镜像层解压到 dir 中，并根据白障文件删除下层的内容
+// keepWhiteouts 为 true 时在 dir 中保留白障文件，用于合并出的层之下还有其它层的情况
+func applyLayer(dir, blobPath string, keepWhiteouts bool) error {
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return applyLayerReader(dir, file, keepWhiteouts)
+}
+
+// 从 r 中读取镜像层并解压到 dir 中
+func applyLayerReader(dir string, layer io.Reader, keepWhiteouts bool) error {
+	r, err := decompressReader(layer)
+	if err != nil {
+		return err
+	}
+
+	fs := &layerFS{layers: []string{dir}}
+	// 当前层中创建的文件，不透明目录只删除之前的层中的内容
+	created := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if name == "." || name == "/" {
+			continue
+		}
+		name = strings.TrimPrefix(name, "/")
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid tar entry %s", hdr.Name)
+		}
+		parent, err := fs.resolve(path.Dir(name), true)
+		if err != nil {
+			return err
+		}
+		if err = fs.mkdirTop(parent); err != nil {
+			return err
+		}
+		base := path.Base(name)
+		parentPath := filepath.Join(dir, parent)
+
+		switch {
+		case base == whiteoutOpaqueDir:
+			files, err := ioutil.ReadDir(parentPath)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if !created[path.Join(parent, f.Name())] {
+					if err = os.RemoveAll(filepath.Join(parentPath, f.Name())); err != nil {
+						return err
+					}
+				}
+			}
+			if keepWhiteouts {
+				if err = createTarEntry(filepath.Join(parentPath, base), "", hdr, tr); err != nil {
+					return err
+				}
+				created[path.Join(parent, base)] = true
+			}
+			continue
+		case strings.HasPrefix(base, whiteoutMetaPrefix):
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			if err = os.RemoveAll(filepath.Join(parentPath, strings.TrimPrefix(base, whiteoutPrefix))); err != nil {
+				return err
+			}
+			if keepWhiteouts {
+				if err = createTarEntry(filepath.Join(parentPath, base), "", hdr, tr); err != nil {
+					return err
+				}
+				created[path.Join(parent, base)] = true
+			}
+			continue
+		}
+
+		rel := path.Join(parent, base)
+		target := filepath.Join(dir, rel)
+		// 之前的层删除过该文件，重新创建的目录不能再看到更下层的内容
+		reopened := false
+		whiteout := filepath.Join(parentPath, whiteoutPrefix+base)
+		if _, err = os.Lstat(whiteout); err == nil {
+			if err = os.Remove(whiteout); err != nil {
+				return err
+			}
+			reopened = true
+		}
+
+		linkSource := ""
+		if hdr.Typeflag == tar.TypeLink {
+			linkRel, err := fs.resolve(strings.TrimPrefix(path.Clean(hdr.Linkname), "/"), false)
+			if err != nil {
+				return err
+			}
+			linkSource = filepath.Join(dir, linkRel)
+		}
+		if err = createTarEntry(target, linkSource, hdr, tr); err != nil {
+			return err
+		}
+		created[rel] = true
+		if reopened && keepWhiteouts && hdr.Typeflag == tar.TypeDir {
+			if err = ioutil.WriteFile(filepath.Join(target, whiteoutOpaqueDir), nil, 0444); err != nil {
+				return err
+			}
+			created[path.Join(rel, whiteoutOpaqueDir)] = true
+		}
+	}
+
+	return nil
+}
+
+// 兼容 tar -czf 生成的压缩包
+func decompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+
+	return br, nil
+}
+
+// 统计写入的字节数
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
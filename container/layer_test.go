@@ -0,0 +1,111 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// 将目录内容打包为层文件
+func buildLayer(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		target := filepath.Join(dir, name)
+		if content == "/" {
+			_ = os.MkdirAll(target, 0755)
+			continue
+		}
+		_ = os.MkdirAll(filepath.Dir(target), 0755)
+		_ = ioutil.WriteFile(target, []byte(content), 0644)
+	}
+	blob := filepath.Join(t.TempDir(), "layer.tar")
+	file, _ := os.Create(blob)
+	defer file.Close()
+	if err := writeLayerTar(dir, file); err != nil {
+		t.Fatal(err)
+	}
+
+	return blob
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	_ = filepath.Walk(dir, func(filePath string, fi os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(dir, filePath)
+		if rel != "." && !fi.IsDir() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+
+	return files
+}
+
+func TestSquashLayers(t *testing.T) {
+	layers := []string{
+		buildLayer(t, map[string]string{"a/x": "x", "a/y": "y", "b/z": "z", "c/k": "k"}),
+		buildLayer(t, map[string]string{".wh.b": "", "a/.wh.x": "", "n": "n"}),
+		buildLayer(t, map[string]string{"c/.wh..wh..opq": "", "c/new": "new", "b/q": "q", ".wh..wh.plnk/1": "1"}),
+	}
+	expected := []string{"a/y", "b/q", "c/new", "n"}
+
+	flat := t.TempDir()
+	for _, layer := range layers {
+		if err := applyLayer(flat, layer, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := listFiles(t, flat); !reflect.DeepEqual(files, expected) {
+		t.Errorf("flatten files = %v, expected %v", files, expected)
+	}
+
+	// 只合并上面两层，白障文件需要保留下来
+	squashedDir := t.TempDir()
+	for _, layer := range layers[1:] {
+		if err := applyLayer(squashedDir, layer, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	squashed := filepath.Join(t.TempDir(), "squashed.tar")
+	file, _ := os.Create(squashed)
+	if err := writeLayerTar(squashedDir, file); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	merged := t.TempDir()
+	for _, layer := range []string{layers[0], squashed} {
+		if err := applyLayer(merged, layer, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := listFiles(t, merged); !reflect.DeepEqual(files, expected) {
+		t.Errorf("squashed files = %v, expected %v", files, expected)
+	}
+}
+
+func TestApplyVerifiedLayer(t *testing.T) {
+	blob := buildLayer(t, map[string]string{"etc/hosts": "hosts"})
+	digest, _, err := fileDigest(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err = applyVerifiedLayer(dir, &layerSource{blobPath: blob, digest: digest}); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"etc/hosts"}) {
+		t.Errorf("applied files = %v, expected [etc/hosts]", files)
+	}
+
+	// 层文件的内容与摘要不一致时不能使用
+	other := buildLayer(t, map[string]string{"etc/hosts": "tampered"})
+	if err = applyVerifiedLayer(t.TempDir(), &layerSource{blobPath: other, digest: digest}); err == nil {
+		t.Errorf("layer with a different digest should be rejected")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+	数据卷在容器的 mount namespace 中以 MS_BIND 方式挂载，不依赖 aufs，也不会出现在宿主机的挂载表中
+	挂载传播方式:
+	private/rprivate 容器与宿主机之间互不传播，为默认值
+	slave/rslave 宿主机上数据卷下的新挂载会传播到容器中，反之不会
+	shared/rshared 双向传播，要求宿主机上数据卷所在的挂载点本身是 shared 的，只作用于数据卷的挂载点，容器根目录仍为 rslave
+	带 r 的方式同时作用于数据卷下的子挂载点
+*/
+
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// 挂载传播方式
+const (
+	PropagationPrivate  = "private"
+	PropagationRPrivate = "rprivate"
+	PropagationSlave    = "slave"
+	PropagationRSlave   = "rslave"
+	PropagationShared   = "shared"
+	PropagationRShared  = "rshared"
+)
+
+var propagationFlags = map[string]uintptr{
+	PropagationPrivate:  syscall.MS_PRIVATE,
+	PropagationRPrivate: syscall.MS_PRIVATE | syscall.MS_REC,
+	PropagationSlave:    syscall.MS_SLAVE,
+	PropagationRSlave:   syscall.MS_SLAVE | syscall.MS_REC,
+	PropagationShared:   syscall.MS_SHARED,
+	PropagationRShared:  syscall.MS_SHARED | syscall.MS_REC,
+}
+
+// 容器中根目录的传播方式为 rslave，宿主机上的挂载仍能传播进来，容器中的挂载不会传播到宿主机
+// 需要双向传播的数据卷只把自己的挂载点设为 shared，不能把整个根目录设为 rshared，否则容器中的挂载会泄露到宿主机
+const rootPropagation = syscall.MS_SLAVE | syscall.MS_REC
+
+// 数据卷 bind mount 的参数，以及挂载之后设置传播方式的参数，未知的传播方式返回 0，沿用根目录的传播方式
+func volumeMountFlags(volume *Volume) (uintptr, uintptr) {
+	flags := uintptr(syscall.MS_BIND)
+	if volume.Recursive {
+		flags |= syscall.MS_REC
+	}
+
+	return flags, propagationFlags[volume.Propagation]
+}
+
+// 将 root 所在的挂载点设为 private
+// 保证之后以 root 为源的 bind mount 不会传播到其它 namespace，同时满足 pivot_root 对父挂载点不能是 shared 的要求
+func makeParentMountPrivate(root string) error {
+	mount, err := mountPointOf(root)
+	if err != nil {
+		return err
+	}
+
+	return syscall.Mount("", mount, "", syscall.MS_PRIVATE, "")
+}
+
+// 在容器根目录 root 下挂载数据卷，必须在 pivot_root 之前调用，此时还能访问宿主机上的路径
+// 按容器内路径从浅到深挂载，保证 /data/logs 挂载在 /data 之上
+func bindVolumes(root string, volumes []*Volume) error {
+	sorted := append([]*Volume{}, volumes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i].Destination) < len(sorted[j].Destination)
+	})
+	rootFS := &layerFS{layers: []string{root}}
+	for _, volume := range sorted {
+		// 在容器根目录内解析软链接，防止挂载到根目录之外
+		rel, err := rootFS.resolve(relPath(volume.Destination), true)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, rel)
+		if err = createMountTarget(volume.Source, target); err != nil {
+			return fmt.Errorf("create mount target %s: %v", volume.Destination, err)
+		}
+
+		flags, propagation := volumeMountFlags(volume)
+		if err = syscall.Mount(volume.Source, target, "bind", flags, ""); err != nil {
+			return fmt.Errorf("bind mount %s: %v", volume, err)
+		}
+		// bind mount 时会忽略 MS_RDONLY，需要重新挂载一次才能只读，子挂载点仍然可写
+		if volume.ReadOnly {
+			if err = syscall.Mount("", target, "", flags|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+				return fmt.Errorf("remount %s read only: %v", volume, err)
+			}
+		}
+		if propagation != 0 {
+			if err = syscall.Mount("", target, "", propagation, ""); err != nil {
+				return fmt.Errorf("set propagation of %s: %v", volume, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 创建挂载点，宿主机路径为文件时创建空文件，否则创建目录
+func createMountTarget(source, target string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// 获取 p 所在的挂载点
+func mountPointOf(p string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	p = path.Clean(p)
+	mountPoint := "/"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) < 5 {
+			continue
+		}
+		mount := unescapeMountPath(fields[4])
+		if (mount == p || strings.HasPrefix(p, mount+"/")) && len(mount) > len(mountPoint) {
+			mountPoint = mount
+		}
+	}
+
+	return mountPoint, scanner.Err()
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestVolumeMountFlags(t *testing.T) {
+	tests := []struct {
+		spec        string
+		bind        uintptr
+		propagation uintptr
+	}{
+		{"/data:/app", syscall.MS_BIND | syscall.MS_REC, syscall.MS_PRIVATE | syscall.MS_REC},
+		{"/data:/app:bind", syscall.MS_BIND, syscall.MS_PRIVATE | syscall.MS_REC},
+		{"/data:/app:private", syscall.MS_BIND | syscall.MS_REC, syscall.MS_PRIVATE},
+		{"/data:/app:slave,bind", syscall.MS_BIND, syscall.MS_SLAVE},
+		{"/data:/app:rslave", syscall.MS_BIND | syscall.MS_REC, syscall.MS_SLAVE | syscall.MS_REC},
+		{"/data:/app:shared", syscall.MS_BIND | syscall.MS_REC, syscall.MS_SHARED},
+		{"/data:/app:rshared,ro", syscall.MS_BIND | syscall.MS_REC, syscall.MS_SHARED | syscall.MS_REC},
+	}
+	for _, test := range tests {
+		volume, err := ParseVolume(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bind, propagation := volumeMountFlags(volume)
+		if bind != test.bind || propagation != test.propagation {
+			t.Errorf("volumeMountFlags(%s) = %#x, %#x, expected %#x, %#x", test.spec, bind, propagation, test.bind, test.propagation)
+		}
+	}
+
+	// 旧版本记录的数据卷没有传播方式，沿用根目录的传播方式
+	if _, propagation := volumeMountFlags(&Volume{Source: "/data", Destination: "/app"}); propagation != 0 {
+		t.Errorf("volume without propagation = %#x, expected 0", propagation)
+	}
+}
@@ -0,0 +1,233 @@
+/*
+	数据卷驱动，local 驱动将数据保存在 /var/lib/docker-go/volumes/卷名/_data
+	其它驱动为外部插件，协议与 docker 的 volume plugin 相同:
+	插件在 /var/run/docker-go/plugins/驱动名.sock 上监听，或者在 驱动名.spec 中写明 unix:// 地址
+	docker-go 通过 HTTP POST 发送 json 请求，例如 /VolumeDriver.Create {"Name": "v1", "Opts": {}}
+	返回的 json 中 Err 不为空表示失败
+*/
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"docker-go/common"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	pluginContentType  = "application/vnd.docker.plugins.v1+json"
+	pluginImplements   = "VolumeDriver"
+	pluginTimeout      = 60 * time.Second
+	pluginSocketSuffix = ".sock"
+	pluginSpecSuffix   = ".spec"
+)
+
+// VolumeDriver 数据卷驱动
+type VolumeDriver interface {
+	// Name 驱动名
+	Name() string
+	// Create 创建数据卷
+	Create(name string, opts map[string]string) error
+	// Remove 删除数据卷及其数据
+	Remove(name string) error
+	// Mount 容器 id 使用数据卷前调用，返回宿主机上可以 bind mount 的路径
+	Mount(name, id string) (string, error)
+	// Unmount 容器 id 不再使用数据卷
+	Unmount(name, id string) error
+	// Path 数据卷在宿主机上的路径，没有挂载时可以为空
+	Path(name string) (string, error)
+}
+
+// 插件请求
+type pluginRequest struct {
+	Name string            `json:"Name"`
+	Opts map[string]string `json:"Opts,omitempty"`
+	ID   string            `json:"ID,omitempty"`
+}
+
+// 插件响应
+type pluginResponse struct {
+	Mountpoint string   `json:"Mountpoint,omitempty"`
+	Implements []string `json:"Implements,omitempty"`
+	Err        string   `json:"Err,omitempty"`
+}
+
+// 获取数据卷驱动，驱动名为空时使用 local 驱动
+func getVolumeDriver(name string) (VolumeDriver, error) {
+	if name == "" || name == common.DefaultVolumeDriver {
+		return &localDriver{}, nil
+	}
+	addr, err := findPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	plugin := newVolumePlugin(name, addr)
+	if err = plugin.activate(); err != nil {
+		return nil, fmt.Errorf("activate volume plugin %s: %v", name, err)
+	}
+
+	return plugin, nil
+}
+
+// 在插件目录中查找驱动的 unix socket 地址
+func findPlugin(name string) (string, error) {
+	if !volumeNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid volume driver name %q", name)
+	}
+	socketPath := path.Join(common.DefaultPluginPath, name+pluginSocketSuffix)
+	if fi, err := os.Stat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		return socketPath, nil
+	}
+	bs, err := ioutil.ReadFile(path.Join(common.DefaultPluginPath, name+pluginSpecSuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("volume driver %s not found in %s", name, common.DefaultPluginPath)
+		}
+		return "", err
+	}
+	spec := strings.TrimSpace(string(bs))
+	if !strings.HasPrefix(spec, "unix://") {
+		return "", fmt.Errorf("volume driver %s: unsupported address %q", name, spec)
+	}
+
+	return strings.TrimPrefix(spec, "unix://"), nil
+}
+
+// local 驱动
+type localDriver struct{}
+
+func (d *localDriver) Name() string {
+	return common.DefaultVolumeDriver
+}
+
+func (d *localDriver) Create(name string, opts map[string]string) error {
+	if len(opts) > 0 {
+		return fmt.Errorf("local volume driver does not support options")
+	}
+	return os.MkdirAll(namedVolumePath(name), 0755)
+}
+
+func (d *localDriver) Remove(name string) error {
+	return os.RemoveAll(namedVolumePath(name))
+}
+
+func (d *localDriver) Mount(name, id string) (string, error) {
+	return d.Path(name)
+}
+
+func (d *localDriver) Unmount(name, id string) error {
+	return nil
+}
+
+func (d *localDriver) Path(name string) (string, error) {
+	return namedVolumePath(name), nil
+}
+
+// 外部数据卷插件
+type volumePlugin struct {
+	name   string
+	client *http.Client
+}
+
+func newVolumePlugin(name, socketPath string) *volumePlugin {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &volumePlugin{
+		name:   name,
+		client: &http.Client{Transport: transport, Timeout: pluginTimeout},
+	}
+}
+
+func (p *volumePlugin) Name() string {
+	return p.name
+}
+
+func (p *volumePlugin) Create(name string, opts map[string]string) error {
+	_, err := p.call("/VolumeDriver.Create", &pluginRequest{Name: name, Opts: opts})
+	return err
+}
+
+func (p *volumePlugin) Remove(name string) error {
+	_, err := p.call("/VolumeDriver.Remove", &pluginRequest{Name: name})
+	return err
+}
+
+func (p *volumePlugin) Mount(name, id string) (string, error) {
+	resp, err := p.call("/VolumeDriver.Mount", &pluginRequest{Name: name, ID: id})
+	if err != nil {
+		return "", err
+	}
+	if resp.Mountpoint == "" {
+		return "", fmt.Errorf("volume plugin %s returned no mountpoint for %s", p.name, name)
+	}
+	return resp.Mountpoint, nil
+}
+
+func (p *volumePlugin) Unmount(name, id string) error {
+	_, err := p.call("/VolumeDriver.Unmount", &pluginRequest{Name: name, ID: id})
+	return err
+}
+
+func (p *volumePlugin) Path(name string) (string, error) {
+	resp, err := p.call("/VolumeDriver.Path", &pluginRequest{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return resp.Mountpoint, nil
+}
+
+// 握手，确认插件实现了数据卷驱动
+func (p *volumePlugin) activate() error {
+	resp, err := p.call("/Plugin.Activate", nil)
+	if err != nil {
+		return err
+	}
+	for _, implement := range resp.Implements {
+		if implement == pluginImplements {
+			return nil
+		}
+	}
+	return fmt.Errorf("plugin does not implement %s", pluginImplements)
+}
+
+// 调用插件接口
+func (p *volumePlugin) call(method string, req *pluginRequest) (*pluginResponse, error) {
+	body := []byte("{}")
+	if req != nil {
+		body, _ = json.Marshal(req)
+	}
+	httpResp, err := p.client.Post("http://plugin"+method, pluginContentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("volume plugin %s %s: %v", p.name, method, err)
+	}
+	defer httpResp.Body.Close()
+	bs, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pluginResponse{}
+	if err = json.Unmarshal(bs, resp); err != nil {
+		return nil, fmt.Errorf("volume plugin %s %s: status %d, %s", p.name, method, httpResp.StatusCode, strings.TrimSpace(string(bs)))
+	}
+	if resp.Err != "" {
+		return nil, fmt.Errorf("volume plugin %s %s: %s", p.name, method, resp.Err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("volume plugin %s %s: status %d", p.name, method, httpResp.StatusCode)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,57 @@
+package container
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumePlugin(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "test.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "volumes")
+	driver := &dirDriver{name: "test", root: root, mounts: make(map[string]map[string]bool)}
+	go func() {
+		_ = http.Serve(listener, newPluginHandler(driver))
+	}()
+	defer listener.Close()
+
+	plugin := newVolumePlugin("test", socketPath)
+	if err = plugin.activate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = plugin.Create("v1", map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	mountpoint, err := plugin.Mount("v1", "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(root, "v1"); mountpoint != expected {
+		t.Errorf("mountpoint = %s, expected %s", mountpoint, expected)
+	}
+	if p, err := plugin.Path("v1"); err != nil || p != mountpoint {
+		t.Errorf("path = %s, %v, expected %s", p, err, mountpoint)
+	}
+	if _, err = plugin.Mount("missing", "c1"); err == nil {
+		t.Error("mount missing volume expected error")
+	}
+	if err = plugin.Remove("v1"); err == nil {
+		t.Error("remove mounted volume expected error")
+	}
+	if err = plugin.Unmount("v1", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = plugin.Remove("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(mountpoint); !os.IsNotExist(err) {
+		t.Errorf("volume dir still exists after remove, err: %v", err)
+	}
+}
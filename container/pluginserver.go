@@ -0,0 +1,185 @@
+/*
+	用于测试的数据卷插件，数据卷保存在 root/卷名 目录下
+	通过 docker-go volume serve-plugin 启动，在插件目录中监听 驱动名.sock
+	实现了 docker volume plugin 协议的全部接口，可以作为编写外部插件的参考
+*/
+
+package container
+
+import (
+	"docker-go/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// 目录驱动，每个数据卷对应 root 下的一个目录
+type dirDriver struct {
+	name string
+	root string
+
+	mu     sync.Mutex
+	mounts map[string]map[string]bool // 卷名 -> 使用该卷的容器
+}
+
+// ServeVolumePlugin 启动测试用的数据卷插件，收到 SIGINT/SIGTERM 后退出
+func ServeVolumePlugin(name, root string) error {
+	if !volumeNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid volume driver name %q", name)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(common.DefaultPluginPath, 0755); err != nil {
+		return err
+	}
+	socketPath := path.Join(common.DefaultPluginPath, name+pluginSocketSuffix)
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		_ = listener.Close()
+	}()
+	logrus.Infof("volume plugin %s listening on %s, root: %s", name, socketPath, root)
+	driver := &dirDriver{name: name, root: root, mounts: make(map[string]map[string]bool)}
+	if err = http.Serve(listener, newPluginHandler(driver)); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *dirDriver) Name() string {
+	return d.name
+}
+
+func (d *dirDriver) Create(name string, opts map[string]string) error {
+	return os.MkdirAll(path.Join(d.root, name), 0755)
+}
+
+func (d *dirDriver) Remove(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.mounts[name]) > 0 {
+		return fmt.Errorf("volume %s is mounted", name)
+	}
+	return os.RemoveAll(path.Join(d.root, name))
+}
+
+func (d *dirDriver) Mount(name, id string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	volumePath := path.Join(d.root, name)
+	if _, err := os.Stat(volumePath); err != nil {
+		return "", err
+	}
+	if d.mounts[name] == nil {
+		d.mounts[name] = make(map[string]bool)
+	}
+	d.mounts[name][id] = true
+	return volumePath, nil
+}
+
+func (d *dirDriver) Unmount(name, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.mounts[name], id)
+	return nil
+}
+
+func (d *dirDriver) Path(name string) (string, error) {
+	volumePath := path.Join(d.root, name)
+	if _, err := os.Stat(volumePath); err != nil {
+		return "", err
+	}
+	return volumePath, nil
+}
+
+// 将目录驱动包装为插件协议的 HTTP 接口
+func newPluginHandler(driver *dirDriver) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(method string, fn func(req *pluginRequest) (interface{}, error)) {
+		mux.HandleFunc(method, func(w http.ResponseWriter, r *http.Request) {
+			req := &pluginRequest{}
+			var resp interface{}
+			err := json.NewDecoder(r.Body).Decode(req)
+			if err == nil {
+				resp, err = fn(req)
+			}
+			w.Header().Set("Content-Type", pluginContentType)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				resp = &pluginResponse{Err: err.Error()}
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		})
+	}
+
+	handle("/Plugin.Activate", func(req *pluginRequest) (interface{}, error) {
+		return &pluginResponse{Implements: []string{pluginImplements}}, nil
+	})
+	handle("/VolumeDriver.Capabilities", func(req *pluginRequest) (interface{}, error) {
+		return map[string]interface{}{"Capabilities": map[string]string{"Scope": "local"}}, nil
+	})
+	handle("/VolumeDriver.Create", func(req *pluginRequest) (interface{}, error) {
+		return &pluginResponse{}, driver.Create(req.Name, req.Opts)
+	})
+	handle("/VolumeDriver.Remove", func(req *pluginRequest) (interface{}, error) {
+		return &pluginResponse{}, driver.Remove(req.Name)
+	})
+	handle("/VolumeDriver.Mount", func(req *pluginRequest) (interface{}, error) {
+		mountpoint, err := driver.Mount(req.Name, req.ID)
+		return &pluginResponse{Mountpoint: mountpoint}, err
+	})
+	handle("/VolumeDriver.Unmount", func(req *pluginRequest) (interface{}, error) {
+		return &pluginResponse{}, driver.Unmount(req.Name, req.ID)
+	})
+	handle("/VolumeDriver.Path", func(req *pluginRequest) (interface{}, error) {
+		mountpoint, err := driver.Path(req.Name)
+		return &pluginResponse{Mountpoint: mountpoint}, err
+	})
+	handle("/VolumeDriver.Get", func(req *pluginRequest) (interface{}, error) {
+		mountpoint, err := driver.Path(req.Name)
+		if err != nil {
+			return nil, err
+		}
+		volume := map[string]string{"Name": req.Name, "Mountpoint": mountpoint}
+		return map[string]interface{}{"Volume": volume, "Err": ""}, nil
+	})
+	handle("/VolumeDriver.List", func(req *pluginRequest) (interface{}, error) {
+		return map[string]interface{}{"Volumes": driver.list(), "Err": ""}, nil
+	})
+
+	return mux
+}
+
+func (d *dirDriver) list() []map[string]string {
+	files, _ := ioutil.ReadDir(d.root)
+	volumes := []map[string]string{}
+	for _, file := range files {
+		if file.IsDir() {
+			volumes = append(volumes, map[string]string{"Name": file.Name(), "Mountpoint": path.Join(d.root, file.Name())})
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i]["Name"] < volumes[j]["Name"]
+	})
+	return volumes
+}
@@ -0,0 +1,94 @@
+/*
+	容器中的内核接口，与 runc 的默认配置相同
+	/sys 以只读方式挂载 sysfs
+	/proc 下可以读取宿主机敏感信息的路径被屏蔽: 文件 bind mount /dev/null，目录挂载一个只读的空 tmpfs
+	/proc/sys、/proc/sysrq-trigger 等可以修改内核配置的路径重新挂载为只读
+	这些路径在不同内核上不一定存在，不存在时跳过
+*/
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// 屏蔽的路径
+var maskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// 只读的路径
+var readonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// 以只读方式挂载 sysfs，容器有独立的 net namespace，可以挂载新的 sysfs
+func mountSysfs() error {
+	if err := os.MkdirAll("/sys", 0755); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", flags, ""); err != nil {
+		return fmt.Errorf("mount sysfs: %v", err)
+	}
+
+	return nil
+}
+
+// 屏蔽 /proc 和 /sys 下的敏感路径，必须在 /proc、/sys 和 /dev/null 准备好之后调用
+func maskKernelPaths() error {
+	for _, p := range maskedPaths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if fi.IsDir() {
+			err = syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", p, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("mask %s: %v", p, err)
+		}
+	}
+
+	return nil
+}
+
+// 将修改内核配置的路径设为只读
+func readonlyKernelPaths() error {
+	for _, p := range readonlyPaths {
+		// bind mount 到自身，使其成为单独的挂载点后才能单独设为只读
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("bind %s: %v", p, err)
+		}
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+		if err := syscall.Mount(p, p, "", flags, ""); err != nil {
+			return fmt.Errorf("remount %s read only: %v", p, err)
+		}
+	}
+
+	return nil
+}
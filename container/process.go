@@ -8,16 +8,23 @@
 package container
 
 import (
+	"docker-go/cgroups"
+	"docker-go/cgroups/subsystem"
 	"docker-go/common"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"syscall"
 )
 
 // NewParentProcess 创建一个会隔离namespace进程的Comand
-func NewParentProcess(tty bool, volume string, containerName, imageName string, envs []string) (*exec.Cmd, *os.File) {
+// 容器的工作空间需要事先准备好，config 稍后通过返回的管道发送给 init 进程
+// 返回的 ContainerIO 是容器标准输入输出在 docker-go 一侧的一端:
+// config.Tty 为 true 时是 console socket，容器的 init 进程通过它发送终端的 master，否则是连接容器标准输入输出的管道
+func NewParentProcess(config *InitConfig, containerName string) (*exec.Cmd, *os.File, *ContainerIO) {
 	readPipe, writePipe, _ := os.Pipe()
 	// 调用自身，传入 init 参数， 也就是执行initComand
 	cmd := exec.Command("/proc/self/exe", "init")
@@ -25,41 +32,76 @@ func NewParentProcess(tty bool, volume string, containerName, imageName string,
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
-	if tty {
-		// 指定交换终端，日志直接输出到控制台
-		cmd.Stdin = os.Stdin
+	// 设置额外文件句柄
+	cmd.ExtraFiles = []*os.File{
+		readPipe,
+	}
+	containerIO := &ContainerIO{inherited: []*os.File{readPipe}}
+	if config.Tty {
+		// 容器有自己的终端，init 进程在新的会话中运行，终端分配好之前的日志直接输出到控制台
+		parentSocket, childSocket, err := newConsoleSocket()
+		if err != nil {
+			logrus.Errorf("new console socket, err: %v", err)
+			return nil, nil, nil
+		}
+		containerIO.Console = parentSocket
+		cmd.SysProcAttr.Setsid = true
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
+		cmd.ExtraFiles = append(cmd.ExtraFiles, childSocket)
+		containerIO.inherited = append(containerIO.inherited, childSocket)
 	} else {
-		// 创建日志存放目录
-		logDir := path.Join(common.DefaultContainerInfoPath, containerName)
-		if _, err := os.Stat(logDir); err != nil && os.IsNotExist(err) {
-			err = os.MkdirAll(logDir, os.ModePerm)
-			if err != nil {
-				logrus.Errorf("mkdir container log, err: %v", err)
-			}
+		// 标准输入输出连接到管道，由 shim 写入日志文件并转发给 attach 的客户端
+		stdinReader, stdinWriter, err := os.Pipe()
+		if err != nil {
+			logrus.Errorf("create stdin pipe, err: %v", err)
+			return nil, nil, nil
 		}
-		// 创建日志文件
-		logFileName := path.Join(logDir, common.ContainerLogFileName)
-		file, err := os.Create(logFileName)
+		outputReader, outputWriter, err := os.Pipe()
 		if err != nil {
-			logrus.Errorf("create log file, err: %v", err)
+			logrus.Errorf("create output pipe, err: %v", err)
+			return nil, nil, nil
 		}
-		// 将cmd的输出流改到日志文件中
-		cmd.Stdout = file
+		containerIO.Stdin, containerIO.Output = stdinWriter, outputReader
+		cmd.Stdin = stdinReader
+		cmd.Stdout = outputWriter
+		cmd.Stderr = outputWriter
+		containerIO.inherited = append(containerIO.inherited, stdinReader, outputWriter)
 	}
-	// 设置额外文件句柄
-	cmd.ExtraFiles = []*os.File{
-		readPipe,
+	// 指定容器初始化后的工作目录，即容器的根目录
+	cmd.Dir = path.Join(common.MntPath, containerName)
+	return cmd, writePipe, containerIO
+}
+
+// LaunchContainer 启动容器的 init 进程，record 记录容器信息，之后设置资源限制并发送 init 进程的配置
+// 返回的 ContainerIO 由调用者处理: 前台运行时与用户的终端互相转发，后台运行时由 shim 写入日志并转发给 attach 的客户端
+func LaunchContainer(config *InitConfig, res *subsystem.ResourceConfig, cgroupManager *cgroups.CGroupManager,
+	containerName string, record func(pid int) error) (*exec.Cmd, *ContainerIO, error) {
+	parent, writePipe, containerIO := NewParentProcess(config, containerName)
+	if parent == nil {
+		return nil, nil, fmt.Errorf("failed to new parent process")
+	}
+	if err := parent.Start(); err != nil {
+		containerIO.CloseInherited()
+		containerIO.Close()
+		_ = writePipe.Close()
+		return nil, nil, fmt.Errorf("parent start failed, err %v", err)
 	}
-	// 设置环境变量
-	cmd.Env = append(os.Environ(), envs...)
-	err := NewWorkSpace(volume, containerName, imageName)
+	containerIO.CloseInherited()
+	// 记录容器信息
+	err := record(parent.Process.Pid)
 	if err != nil {
-		logrus.Errorf("new work space, err: %v", err)
+		logrus.Errorf("record container info, err: %v", err)
+	}
+	// 设置资源限制
+	cgroupManager.Set(res)
+	// 将容器进程，加入到各个subsystem挂载对应的cgroup中
+	cgroupManager.Apply(parent.Process.Pid)
+	// 发送 init 进程的配置
+	logrus.Infof("command all is %s", strings.Join(config.Args, " "))
+	if err = SendInitConfig(config, writePipe); err != nil {
+		logrus.Errorf("send init config, err: %v", err)
 	}
 
-	// 指定容器初始化后的工作目录
-	cmd.Dir = common.MntPath
-	return cmd, writePipe
+	return parent, containerIO, nil
 }
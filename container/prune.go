@@ -0,0 +1,426 @@
+/*
+	清理不再使用的资源，类似 docker system prune
+	容器: 已停止容器的信息目录、读写层和挂载点
+	镜像: 没有被任何容器使用的镜像解压目录(镜像 tar 包保留，下次运行时重新解压)
+	系统: 在上面两者的基础上，再清理没有容器的读写层、挂载点和信息目录，以及没有镜像引用的镜像层
+	数据卷: 没有被任何容器引用的命名数据卷，系统清理时不包括数据卷
+*/
+
+package container
+
+import (
+	"bufio"
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// 清理的资源类型
+const (
+	pruneContainer = "container"
+	pruneImage     = "image"
+	pruneLayer     = "layer"
+	pruneMount     = "mount"
+	pruneState     = "state"
+	pruneVolume    = "volume"
+)
+
+// PruneOptions 清理选项
+type PruneOptions struct {
+	DryRun bool      // 只打印将被清理的资源，不实际删除
+	Until  time.Time // 只清理在该时间之前创建的资源，零值表示不限制
+}
+
+// 待清理的资源
+type pruneItem struct {
+	kind   string
+	name   string
+	path   string
+	size   int64
+	remove func() error
+}
+
+// NewPruneOptions 根据命令行参数生成清理选项，filters 的格式为 key=value
+func NewPruneOptions(dryRun bool, filters []string) (*PruneOptions, error) {
+	opts := &PruneOptions{DryRun: dryRun}
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid filter %q, expected key=value", filter)
+		}
+		switch kv[0] {
+		case "until":
+			until, err := parseUntil(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			opts.Until = until
+		default:
+			return nil, fmt.Errorf("invalid filter %q", kv[0])
+		}
+	}
+
+	return opts, nil
+}
+
+// until 支持时间间隔(如 24h)、unix 时间戳和日期时间
+func parseUntil(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid until filter %q", value)
+}
+
+func (o *PruneOptions) match(created time.Time) bool {
+	return o.Until.IsZero() || created.Before(o.Until)
+}
+
+// PruneContainers 清理所有已停止的容器
+func PruneContainers(opts *PruneOptions) error {
+	items, _ := containerPruneItems(listContainerInfos(), opts)
+	return prune(items, opts)
+}
+
+// PruneImages 清理没有被容器使用的镜像解压目录
+func PruneImages(opts *PruneOptions) error {
+	return prune(imagePruneItems(listContainerInfos(), opts), opts)
+}
+
+// PruneSystem 清理已停止的容器、未使用的镜像以及无主的读写层、挂载点和信息目录
+func PruneSystem(opts *PruneOptions) error {
+	infos := listContainerInfos()
+	items, kept := containerPruneItems(infos, opts)
+	items = append(items, imagePruneItems(kept, opts)...)
+	items = append(items, orphanPruneItems(infos, opts)...)
+
+	return prune(items, opts)
+}
+
+// 打印并删除待清理的资源，统计回收的空间
+func prune(items []pruneItem, opts *PruneOptions) error {
+	var total int64
+	var failed int
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 2, ' ', 0)
+	_, _ = fmt.Fprint(w, "TYPE\tNAME\tPATH\tSIZE\n")
+	for _, item := range items {
+		if !opts.DryRun {
+			if err := item.remove(); err != nil {
+				logrus.Errorf("prune %s %s, err: %v", item.kind, item.name, err)
+				failed++
+				continue
+			}
+		}
+		total += item.size
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.kind, item.name, item.path, humanSize(item.size))
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush prune, err: %v", err)
+	}
+
+	if opts.DryRun {
+		_, _ = fmt.Fprintf(os.Stdout, "Total reclaimable space: %s\n", humanSize(total))
+	} else {
+		_, _ = fmt.Fprintf(os.Stdout, "Total reclaimed space: %s\n", humanSize(total))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to prune %d item(s)", failed)
+	}
+
+	return nil
+}
+
+// 已停止的容器，同时返回保留下来的容器
+func containerPruneItems(infos []*ContainerInfo, opts *PruneOptions) ([]pruneItem, []*ContainerInfo) {
+	var items []pruneItem
+	var kept []*ContainerInfo
+	for _, info := range infos {
+		created, _ := time.ParseInLocation("2006-01-02 15:04:05", info.CreateTime, time.Local)
+		if info.Status == common.Running || !opts.match(created) {
+			kept = append(kept, info)
+			continue
+		}
+		containerName := info.Name
+		writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
+		stateDir := path.Join(common.DefaultContainerInfoPath, containerName)
+		items = append(items, pruneItem{
+			kind: pruneContainer,
+			name: containerName,
+			path: writeLayerPath,
+			size: dirSize(writeLayerPath) + dirSize(stateDir),
+			remove: func() error {
+				return removeContainerFiles(containerName)
+			},
+		})
+	}
+
+	return items, kept
+}
+
+// 没有被 infos 中的容器使用的镜像解压目录
+func imagePruneItems(infos []*ContainerInfo, opts *PruneOptions) []pruneItem {
+	used := make(map[string]bool)
+	for _, info := range infos {
+		used[info.Image] = true
+	}
+	var items []pruneItem
+	for _, file := range listImageDirs() {
+		imageName := file.Name()
+		if used[imageName] || !opts.match(file.ModTime()) {
+			continue
+		}
+		imagePath := path.Join(common.RootPath, imageName)
+		items = append(items, pruneItem{
+			kind: pruneImage,
+			name: imageName,
+			path: imagePath,
+			size: dirSize(imagePath),
+			remove: func() error {
+				return os.RemoveAll(imagePath)
+			},
+		})
+	}
+
+	return items
+}
+
+// 不属于任何容器的读写层、挂载点和信息目录
+func orphanPruneItems(infos []*ContainerInfo, opts *PruneOptions) []pruneItem {
+	known := make(map[string]bool)
+	for _, info := range infos {
+		known[info.Name] = true
+	}
+	var items []pruneItem
+
+	writeLayerDir := path.Join(common.RootPath, common.WriteLayer)
+	for _, file := range orphanDirs(writeLayerDir, known, opts) {
+		layerPath := path.Join(writeLayerDir, file.Name())
+		items = append(items, pruneItem{
+			kind: pruneLayer,
+			name: file.Name(),
+			path: layerPath,
+			size: dirSize(layerPath),
+			remove: func() error {
+				// 读写层可能是挂载着的 loop 设备，先卸载并删除镜像文件
+				if err := removeLoopback(layerPath); err != nil {
+					return err
+				}
+				return os.RemoveAll(layerPath)
+			},
+		})
+	}
+	// 读写层目录已经不在的 loop 设备镜像文件
+	for _, file := range orphanLoopbackImages(writeLayerDir, known, opts) {
+		imagePath := path.Join(writeLayerDir, file.Name())
+		items = append(items, pruneItem{
+			kind: pruneLayer,
+			name: file.Name(),
+			path: imagePath,
+			size: file.Size(),
+			remove: func() error {
+				return os.Remove(imagePath)
+			},
+		})
+	}
+
+	// 没有被任何镜像清单引用的镜像层
+	referenced := make(map[string]bool)
+	for _, manifest := range listImageManifests() {
+		for _, layer := range manifest.Layers {
+			referenced[layerStorePath(layer.Digest)] = true
+		}
+	}
+	blobs, _ := ioutil.ReadDir(common.ImageLayerPath)
+	for _, blob := range blobs {
+		blobPath := path.Join(common.ImageLayerPath, blob.Name())
+		if referenced[blobPath] || !opts.match(blob.ModTime()) {
+			continue
+		}
+		items = append(items, pruneItem{
+			kind: pruneLayer,
+			name: blob.Name(),
+			path: blobPath,
+			size: dirSize(blobPath),
+			remove: func() error {
+				return os.RemoveAll(blobPath)
+			},
+		})
+	}
+
+	for _, file := range orphanDirs(common.MntPath, known, opts) {
+		mntPath := path.Join(common.MntPath, file.Name())
+		var size int64
+		if mounts, _ := mountPointsUnder(mntPath); len(mounts) == 0 {
+			size = dirSize(mntPath)
+		}
+		items = append(items, pruneItem{
+			kind: pruneMount,
+			name: file.Name(),
+			path: mntPath,
+			size: size,
+			remove: func() error {
+				if err := unmountAll(mntPath); err != nil {
+					return err
+				}
+				return os.RemoveAll(mntPath)
+			},
+		})
+	}
+
+	for _, file := range orphanDirs(common.DefaultContainerInfoPath, known, opts) {
+		stateDir := path.Join(common.DefaultContainerInfoPath, file.Name())
+		if isReservedStateDir(file.Name()) {
+			continue
+		}
+		if _, err := os.Stat(path.Join(stateDir, common.ContainerInfoFileName)); err == nil {
+			continue
+		}
+		items = append(items, pruneItem{
+			kind: pruneState,
+			name: file.Name(),
+			path: stateDir,
+			size: dirSize(stateDir),
+			remove: func() error {
+				return os.RemoveAll(stateDir)
+			},
+		})
+	}
+
+	return items
+}
+
+// dir 下不属于已知容器的子目录
+func orphanDirs(dir string, known map[string]bool, opts *PruneOptions) []os.FileInfo {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var orphans []os.FileInfo
+	for _, file := range files {
+		if file.IsDir() && !known[file.Name()] && opts.match(file.ModTime()) {
+			orphans = append(orphans, file)
+		}
+	}
+
+	return orphans
+}
+
+// dir 下不属于已知容器、对应的读写层目录也不存在的 loop 设备镜像文件
+func orphanLoopbackImages(dir string, known map[string]bool, opts *PruneOptions) []os.FileInfo {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var orphans []os.FileInfo
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), loopbackImage)
+		if file.IsDir() || name == file.Name() || known[name] || !opts.match(file.ModTime()) {
+			continue
+		}
+		// 读写层目录还在时随目录一起清理
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			continue
+		}
+		orphans = append(orphans, file)
+	}
+
+	return orphans
+}
+
+// 删除容器的挂载点、读写层和信息目录
+func removeContainerFiles(containerName string) error {
+	mntPath := path.Join(common.MntPath, containerName)
+	// 挂载点下可能还挂载着数据卷，必须先卸载，否则会删除宿主机上的文件
+	if err := unmountAll(mntPath); err != nil {
+		logrus.Errorf("unmount %s, err: %v", mntPath, err)
+		return err
+	}
+	if err := os.RemoveAll(mntPath); err != nil {
+		return err
+	}
+	if err := deleteWriteLayer(containerName); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(path.Join(common.DefaultContainerInfoPath, containerName))
+}
+
+// 卸载 root 及其下的所有挂载点
+func unmountAll(root string) error {
+	mounts, err := mountPointsUnder(root)
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if err = syscall.Unmount(mount, syscall.MNT_DETACH); err != nil {
+			return fmt.Errorf("unmount %s: %v", mount, err)
+		}
+	}
+
+	return nil
+}
+
+// 获取 root 及其下的所有挂载点，按从深到浅的顺序排列
+func mountPointsUnder(root string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	root = path.Clean(root)
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) < 5 {
+			continue
+		}
+		mount := unescapeMountPath(fields[4])
+		if mount == root || strings.HasPrefix(mount, root+"/") {
+			mounts = append(mounts, mount)
+		}
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i]) > len(mounts[j])
+	})
+
+	return mounts, scanner.Err()
+}
+
+// mountinfo 中的空格等字符以 \040 这样的八进制转义
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
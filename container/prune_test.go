@@ -0,0 +1,99 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestNewPruneOptions(t *testing.T) {
+	opts, err := NewPruneOptions(true, nil)
+	if err != nil || !opts.DryRun || !opts.Until.IsZero() {
+		t.Errorf("NewPruneOptions(nil) = %+v, %v, expected dry run without until", opts, err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		filter   string
+		expected time.Time
+	}{
+		{"until=24h", now.Add(-24 * time.Hour)},
+		{"until=90m", now.Add(-90 * time.Minute)},
+		{"until=1700000000", time.Unix(1700000000, 0)},
+		{"until=2023-11-14T22:13:20Z", time.Unix(1700000000, 0)},
+		{"until=2023-11-14T22:13:20+08:00", time.Unix(1700000000-8*3600, 0)},
+		{"until=2023-11-14 22:13:20", time.Date(2023, 11, 14, 22, 13, 20, 0, time.Local)},
+		{"until=2023-11-14", time.Date(2023, 11, 14, 0, 0, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		opts, err := NewPruneOptions(false, []string{test.filter})
+		if err != nil {
+			t.Errorf("NewPruneOptions(%s), err: %v", test.filter, err)
+			continue
+		}
+		// 时间间隔相对于当前时间，允许有少量误差
+		if diff := opts.Until.Sub(test.expected); diff < -time.Second || diff > time.Second {
+			t.Errorf("NewPruneOptions(%s).Until = %v, expected %v", test.filter, opts.Until, test.expected)
+		}
+	}
+
+	for _, filter := range []string{"until", "until=", "until=yesterday", "label=a=b", "before=24h", "=24h"} {
+		if _, err := NewPruneOptions(false, []string{filter}); err == nil {
+			t.Errorf("NewPruneOptions(%s) should fail", filter)
+		}
+	}
+}
+
+func TestPruneOptionsMatch(t *testing.T) {
+	until := time.Unix(1700000000, 0)
+	opts := &PruneOptions{Until: until}
+	if !opts.match(until.Add(-time.Second)) || opts.match(until) || opts.match(until.Add(time.Second)) {
+		t.Errorf("only resources created before until should match")
+	}
+	if !(&PruneOptions{}).match(time.Now()) {
+		t.Errorf("all resources should match without until")
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	tests := map[string]string{
+		"/root/mnt/a":         "/root/mnt/a",
+		`/root/mnt/my\040dir`: "/root/mnt/my dir",
+		`/a\011b\012c\134d`:   "/a\tb\nc\\d",
+		`/a\04`:               `/a\04`,
+		`/a\0x1`:              `/a\0x1`,
+	}
+	for s, expected := range tests {
+		if unescaped := unescapeMountPath(s); unescaped != expected {
+			t.Errorf("unescapeMountPath(%q) = %q, expected %q", s, unescaped, expected)
+		}
+	}
+}
+
+func TestOrphanLoopbackImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// orphan.img 没有读写层目录，withdir.img 随目录一起清理，known.img 属于已知容器
+	for _, name := range []string{"orphan.img", "withdir.img", "known.img", "other.txt"} {
+		if err = ioutil.WriteFile(path.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(path.Join(dir, "withdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := orphanLoopbackImages(dir, map[string]bool{"known": true}, &PruneOptions{})
+	if len(files) != 1 || files[0].Name() != "orphan.img" {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("orphan images = %v, expected [orphan.img]", names)
+	}
+}
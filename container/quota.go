@@ -0,0 +1,352 @@
+/*
+	容器读写层的磁盘配额，通过 --storage-opt size=10G 指定
+	读写层所在的文件系统为 xfs 或 ext4 且开启了 prjquota 时使用项目配额:
+	为读写层目录分配一个项目 ID 并设置继承标记，之后在目录下创建的文件都计入该项目，再通过 quotactl 设置上限
+	不支持项目配额时，创建一个指定大小的 ext4 镜像文件，通过 loop 设备挂载到读写层目录
+*/
+
+package container
+
+import (
+	"bufio"
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// 读写层配额的实现方式
+const (
+	QuotaProject  = "project"
+	QuotaLoopback = "loopback"
+)
+
+const (
+	xfsSuperMagic  = 0x58465342
+	ext4SuperMagic = 0xef53
+
+	fsIocGetXattr      = 0x801c581f
+	fsIocSetXattr      = 0x401c5820
+	fsXflagProjInherit = 0x200
+
+	prjQuota      = 2
+	qXSetQLim     = 0x5804   // xfs 设置配额
+	qSetQuota     = 0x800008 // ext4 设置配额
+	fsDquotVer    = 1
+	fsProjQuota   = 2
+	fsDqBSoft     = 1 << 2
+	fsDqBHard     = 1 << 3
+	qifBLimits    = 1
+	qifBlockSize  = 1024
+	xfsBlockSize  = 512
+	minProjectId  = 100000
+	loopbackImage = ".img"
+)
+
+// 对应内核的 struct fsxattr
+type fsXattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// 对应内核的 struct fs_disk_quota，xfs 使用
+type fsDiskQuota struct {
+	version      int8
+	flags        int8
+	fieldmask    uint16
+	id           uint32
+	blkHardlimit uint64
+	blkSoftlimit uint64
+	inoHardlimit uint64
+	inoSoftlimit uint64
+	bcount       uint64
+	icount       uint64
+	itimer       int32
+	btimer       int32
+	iwarns       uint16
+	bwarns       uint16
+	itimerHi     int8
+	btimerHi     int8
+	rtbtimerHi   int8
+	padding2     int8
+	rtbHardlimit uint64
+	rtbSoftlimit uint64
+	rtbcount     uint64
+	rtbtimer     int32
+	rtbwarns     uint16
+	padding3     int16
+	padding4     [8]byte
+}
+
+// 对应内核的 struct if_dqblk，ext4 使用
+type ifDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// ParseStorageOpts 解析 --storage-opt 参数，目前只支持 size，返回读写层大小上限
+func ParseStorageOpts(opts []string) (int64, error) {
+	var size int64
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] != "size" {
+			return 0, fmt.Errorf("invalid storage option %q, only size=<size> is supported", opt)
+		}
+		if size > 0 {
+			return 0, fmt.Errorf("duplicate storage option %s", kv[0])
+		}
+		var err error
+		if size, err = parseSize(kv[1]); err != nil {
+			return 0, err
+		}
+	}
+
+	return size, nil
+}
+
+// 为读写层设置大小上限，返回使用的方式
+func setWriteLayerQuota(containerName string, size int64) (string, error) {
+	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
+	err := setProjectQuota(writeLayerPath, size)
+	if err == nil {
+		return QuotaProject, nil
+	}
+	logrus.Infof("project quota unavailable for %s, use loopback, reason: %v", writeLayerPath, err)
+	if err = mountLoopback(writeLayerPath, size); err != nil {
+		return "", err
+	}
+
+	return QuotaLoopback, nil
+}
+
+// 使用项目配额限制目录大小
+func setProjectQuota(dir string, size int64) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return err
+	}
+	if stat.Type != xfsSuperMagic && stat.Type != ext4SuperMagic {
+		return fmt.Errorf("filesystem type 0x%x does not support project quota", stat.Type)
+	}
+	device, err := backingDevice(dir)
+	if err != nil {
+		return err
+	}
+	projectId, err := nextProjectId(path.Dir(dir))
+	if err != nil {
+		return err
+	}
+
+	// 先设置配额，失败时(文件系统没有开启 prjquota)目录保持原样
+	deviceName, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	if stat.Type == xfsSuperMagic {
+		quota := &fsDiskQuota{
+			version:      fsDquotVer,
+			flags:        fsProjQuota,
+			fieldmask:    fsDqBSoft | fsDqBHard,
+			id:           projectId,
+			blkHardlimit: uint64(size) / xfsBlockSize,
+			blkSoftlimit: uint64(size) / xfsBlockSize,
+		}
+		err = quotactl(qXSetQLim, deviceName, projectId, unsafe.Pointer(quota))
+	} else {
+		quota := &ifDqblk{
+			bhardlimit: uint64(size) / qifBlockSize,
+			bsoftlimit: uint64(size) / qifBlockSize,
+			valid:      qifBLimits,
+		}
+		err = quotactl(qSetQuota, deviceName, projectId, unsafe.Pointer(quota))
+	}
+	if err != nil {
+		return fmt.Errorf("set quota on %s: %v", device, err)
+	}
+
+	return setProjectId(dir, projectId)
+}
+
+func quotactl(cmd int, device *byte, id uint32, addr unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota), uintptr(unsafe.Pointer(device)),
+		uintptr(id), uintptr(addr), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// 读取目录的项目 ID
+func getProjectId(dir string) (uint32, error) {
+	attr, err := getFsXattr(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	return attr.projid, nil
+}
+
+// 设置目录的项目 ID，并让目录下新建的文件继承该项目 ID
+func setProjectId(dir string, projectId uint32) error {
+	attr, err := getFsXattr(dir)
+	if err != nil {
+		return err
+	}
+	attr.projid = projectId
+	attr.xflags |= fsXflagProjInherit
+
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fsIocSetXattr, uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return fmt.Errorf("set project id of %s: %v", dir, errno)
+	}
+
+	return nil
+}
+
+func getFsXattr(dir string) (*fsXattr, error) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	attr := &fsXattr{}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fsIocGetXattr, uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return nil, fmt.Errorf("get project id of %s: %v", dir, errno)
+	}
+
+	return attr, nil
+}
+
+// 分配项目 ID，取 parent 下已有读写层的最大项目 ID 加一
+func nextProjectId(parent string) (uint32, error) {
+	projectId := uint32(minProjectId)
+	files, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		id, err := getProjectId(path.Join(parent, file.Name()))
+		if err == nil && id >= projectId {
+			projectId = id + 1
+		}
+	}
+
+	return projectId, nil
+}
+
+// 获取 dir 所在文件系统的块设备
+func backingDevice(dir string) (string, error) {
+	mountPoint, err := mountPointOf(dir)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	device := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		line := scanner.Text()
+		fields := strings.Split(line, " ")
+		if len(fields) < 5 || unescapeMountPath(fields[4]) != mountPoint {
+			continue
+		}
+		i := strings.Index(line, " - ")
+		if i < 0 {
+			continue
+		}
+		extra := strings.Fields(line[i+3:])
+		if len(extra) >= 2 {
+			device = unescapeMountPath(extra[1])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(device, "/dev/") {
+		return "", fmt.Errorf("no block device for %s", mountPoint)
+	}
+
+	return device, nil
+}
+
+// 使用指定大小的 ext4 镜像文件作为读写层
+func mountLoopback(dir string, size int64) error {
+	image := dir + loopbackImage
+	file, err := os.OpenFile(image, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(image)
+		return err
+	}
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput(); err != nil {
+		_ = os.Remove(image)
+		return fmt.Errorf("mkfs.ext4 %s: %v, %s", image, err, strings.TrimSpace(string(out)))
+	}
+	if err = mountLoopbackImage(image, dir); err != nil {
+		_ = os.Remove(image)
+		return err
+	}
+	// mkfs 创建的 lost+found 不能出现在容器中
+	_ = os.RemoveAll(path.Join(dir, "lost+found"))
+
+	return nil
+}
+
+// 将读写层的镜像文件挂载到 dir
+func mountLoopbackImage(image, dir string) error {
+	if out, err := exec.Command("mount", "-o", "loop", image, dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount %s: %v, %s", image, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// 卸载并删除读写层的镜像文件
+func removeLoopback(dir string) error {
+	if err := unmountAll(dir); err != nil {
+		return err
+	}
+	if err := os.Remove(dir + loopbackImage); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestParseStorageOpts(t *testing.T) {
+	cases := map[string]int64{
+		"size=10G":   10 << 30,
+		"size=512m":  512 << 20,
+		"size=1.5gb": 3 << 29,
+		"size=4096":  4096,
+	}
+	for opt, expected := range cases {
+		size, err := ParseStorageOpts([]string{opt})
+		if err != nil {
+			t.Fatalf("ParseStorageOpts(%q): %v", opt, err)
+		}
+		if size != expected {
+			t.Errorf("ParseStorageOpts(%q) = %d, expected %d", opt, size, expected)
+		}
+	}
+	if size, err := ParseStorageOpts(nil); err != nil || size != 0 {
+		t.Errorf("ParseStorageOpts(nil) = %d, %v, expected no limit", size, err)
+	}
+
+	invalid := [][]string{
+		{"size=10x"},
+		{"size="},
+		{"size=-1G"},
+		{"size"},
+		{"inodes=1000"},
+		{"size=1G", "size=2G"},
+	}
+	for _, opts := range invalid {
+		if _, err := ParseStorageOpts(opts); err == nil {
+			t.Errorf("ParseStorageOpts(%q) expected error", opts)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+	--init 时 docker-go init 不 exec 用户命令，而是作为容器的 1 号进程一直运行:
+	1. 用户命令在自己的进程组中运行，有终端时是终端的前台进程组
+	2. 收到的信号转发给用户命令的进程组，没有安装信号处理函数的程序也能被 SIGTERM 停止
+	3. 回收容器中所有变成孤儿的进程，避免僵尸进程堆积
+	4. 用户命令退出后以它的退出状态退出，被信号杀死时退出码为 128+信号
+*/
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// 以 1 号进程的身份运行用户命令，用户命令退出后当前进程以相同的状态退出
+func runAsInit(path string, args, env []string, tty bool) error {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+	cmd, err := startInitChild(path, args, env, tty)
+	if err != nil {
+		return err
+	}
+	child := cmd.Process.Pid
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGCHLD:
+			if status, exited := reapChildren(child); exited {
+				os.Exit(exitCodeOf(status))
+			}
+		case syscall.SIGURG:
+			// go 运行时用于抢占调度，不转发
+		default:
+			forwardSignal(child, sig.(syscall.Signal))
+		}
+	}
+
+	return nil
+}
+
+// 在新的进程组中启动用户命令，有终端时设为终端的前台进程组
+// 子进程在 fork 之后屏蔽了所有信号，设置前台进程组时不会因为 SIGTTOU 停止，exec 之前恢复信号屏蔽字
+// 用户命令启动之后才忽略 SIGTTIN 和 SIGTTOU，忽略的信号在 exec 之后仍然被忽略，会破坏用户命令中 shell 的作业控制
+func startInitChild(path string, args, env []string, tty bool) (*exec.Cmd, error) {
+	cmd := &exec.Cmd{
+		Path:        path,
+		Args:        args,
+		Env:         env,
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+	}
+	if tty {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = 0
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %v", path, err)
+	}
+	// 当前进程已不在前台进程组，操作终端时会收到这两个信号
+	signal.Ignore(syscall.SIGTTIN, syscall.SIGTTOU)
+
+	return cmd, nil
+}
+
+// 回收所有已经退出的子进程，用户命令退出时返回它的退出状态
+func reapChildren(child int) (syscall.WaitStatus, bool) {
+	var childStatus syscall.WaitStatus
+	childExited := false
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return childStatus, childExited
+		}
+		if pid == child {
+			childStatus, childExited = status, true
+		}
+	}
+}
+
+// 将信号转发给用户命令的进程组，进程组不存在时只发给用户命令
+func forwardSignal(child int, sig syscall.Signal) {
+	if err := syscall.Kill(-child, sig); err == syscall.ESRCH {
+		_ = syscall.Kill(child, sig)
+	}
+}
+
+// 退出状态对应的退出码
+func exitCodeOf(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return status.ExitStatus()
+}
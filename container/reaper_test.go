@@ -0,0 +1,79 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestForwardSignalAndReap(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	child := cmd.Process.Pid
+	forwardSignal(child, syscall.SIGTERM)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status, exited := reapChildren(child); exited {
+			if code := exitCodeOf(status); code != 128+int(syscall.SIGTERM) {
+				t.Errorf("exit code %d, expected %d", code, 128+int(syscall.SIGTERM))
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("child was not reaped")
+}
+
+// 进程忽略的信号集合
+func ignoredSignals(t *testing.T, pid int) uint64 {
+	bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if strings.HasPrefix(line, "SigIgn:") {
+			mask, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "SigIgn:")), 16, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mask
+		}
+	}
+	t.Fatal("no SigIgn in status")
+
+	return 0
+}
+
+func TestStartInitChildSignals(t *testing.T) {
+	defer signal.Reset(syscall.SIGTTIN, syscall.SIGTTOU)
+	cmd, err := startInitChild("/bin/sleep", []string{"sleep", "10"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	// 用户命令不能继承被忽略的 SIGTTIN 和 SIGTTOU，否则 shell 的作业控制不能工作
+	ttyMask := uint64(1)<<(syscall.SIGTTIN-1) | uint64(1)<<(syscall.SIGTTOU-1)
+	if mask := ignoredSignals(t, cmd.Process.Pid); mask&ttyMask != 0 {
+		t.Errorf("child ignores signals %#x, SIGTTIN and SIGTTOU must not be ignored", mask)
+	}
+	if !signal.Ignored(syscall.SIGTTIN) || !signal.Ignored(syscall.SIGTTOU) {
+		t.Errorf("init should ignore SIGTTIN and SIGTTOU after starting the child")
+	}
+	if pgid, err := syscall.Getpgid(cmd.Process.Pid); err != nil || pgid != cmd.Process.Pid {
+		t.Errorf("child pgid = %d, %v, expected its own process group", pgid, err)
+	}
+}
@@ -12,10 +12,12 @@ func RemoveContainer(containerName string) {
 	info, err := getContainerInfo(containerName)
 	if err != nil {
 		logrus.Errorf("get container info, err: %v", err)
+		return
 	}
 
-	// 只能删除停止状态的容器
-	if info.Status != common.Stop {
+	// 只能删除停止或者已经退出的容器
+	refreshContainerStatus(info)
+	if info.Status == common.Running {
 		logrus.Errorf("can't remove running container")
 		return
 	}
@@ -0,0 +1,116 @@
+/*
+	后台运行的容器由一个独立的 shim 进程监护，docker-go 命令启动 shim 后就退出，shim 在新的会话中运行:
+	1. 按照容器信息中记录的配置启动容器的 init 进程，是 init 进程的父进程
+	2. 持有容器的标准输入输出，写入日志并转发给 attach 的客户端
+	3. 容器运行期间保留容器的 cgroup
+	4. 回收 init 进程，记录退出状态，通知数据卷驱动，删除 cgroup 后退出
+	init 进程启动的结果通过管道(fd 3)告诉 docker-go 命令，启动失败时写入错误信息
+	shim 自身的输出写入容器信息目录下的 shim.log
+*/
+
+package container
+
+import (
+	"docker-go/cgroups"
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// StartShim 启动容器的 shim 进程，容器的 init 进程启动后返回
+func StartShim(containerName string) error {
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readPipe.Close()
+	logFileName := path.Join(common.DefaultContainerInfoPath, containerName, common.ShimLogFileName)
+	logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		_ = writePipe.Close()
+		return fmt.Errorf("open shim log: %v", err)
+	}
+	defer logFile.Close()
+	cmd := exec.Command("/proc/self/exe", "shim", containerName)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.ExtraFiles = []*os.File{writePipe}
+	// 新的会话，关闭终端和 docker-go 命令退出后继续运行
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	_ = writePipe.Close()
+	if err != nil {
+		return fmt.Errorf("start shim: %v", err)
+	}
+	// shim 启动容器后关闭管道，出错时先写入错误信息
+	bs, err := ioutil.ReadAll(readPipe)
+	if err != nil {
+		return fmt.Errorf("read shim: %v", err)
+	}
+	if msg := strings.TrimSpace(string(bs)); msg != "" {
+		_ = cmd.Wait()
+		return fmt.Errorf("start container %s: %s", containerName, msg)
+	}
+
+	return cmd.Process.Release()
+}
+
+// RunShim 在 shim 进程中运行，容器退出并清理完成后返回
+func RunShim(containerName string) error {
+	ready := os.NewFile(3, "shim-ready")
+	syscall.CloseOnExec(3)
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return reportShimError(ready, err)
+	}
+	if info.Config == nil {
+		return reportShimError(ready, fmt.Errorf("container %s has no recorded config", containerName))
+	}
+	// shim 在新的会话中运行，没有控制终端，不会收到终端的 SIGHUP 和 SIGINT
+	// 这里不能忽略信号，忽略的信号在 exec 之后仍然被忽略，容器中的进程会收不到这些信号
+
+	cgroupManager := cgroups.NewCGroupManager(CgroupPath(containerName))
+	parent, containerIO, err := LaunchContainer(info.Config, info.Resources, cgroupManager, containerName, func(pid int) error {
+		return SetContainerRunning(containerName, pid, os.Getpid())
+	})
+	if err != nil {
+		cgroupManager.Destroy()
+		return reportShimError(ready, err)
+	}
+	_ = ready.Close()
+
+	consoleDone := make(chan struct{})
+	go func() {
+		if err := serveConsole(containerName, containerIO); err != nil {
+			logrus.Errorf("serve console of %s, err: %v", containerName, err)
+		}
+		close(consoleDone)
+	}()
+
+	// 回收 init 进程，init 进程退出后容器 pid namespace 中的其他进程都会被杀死，输出随之结束
+	if err = parent.Wait(); err != nil {
+		logrus.Infof("container %s exited, %v", containerName, err)
+	}
+	<-consoleDone
+	if err = RecordContainerExit(containerName, parent.ProcessState); err != nil {
+		logrus.Errorf("record container exit, err: %v", err)
+	}
+	// 通知数据卷驱动容器不再使用数据卷，最后删除 cgroup
+	releaseVolumes(info.Config.Volumes, containerName)
+	cgroupManager.Destroy()
+
+	return nil
+}
+
+// 将错误告诉等待的 docker-go 命令
+func reportShimError(ready *os.File, err error) error {
+	_, _ = ready.WriteString(err.Error())
+	_ = ready.Close()
+
+	return err
+}
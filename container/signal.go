@@ -0,0 +1,52 @@
+package container
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 停止容器时默认发送的信号
+const defaultStopSignal = "SIGTERM"
+
+// 最大的实时信号
+const maxSignal = 64
+
+// ParseSignal 解析信号，支持 SIGKILL、KILL、kill 和数字 9 这几种写法
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > maxSignal {
+			return 0, fmt.Errorf("invalid signal %s", s)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("invalid signal %s", s)
+	}
+
+	return sig, nil
+}
+
+// ResolveStopSignal 确定容器的停止信号，优先使用 --stop-signal，其次是镜像中记录的，都没有时为 SIGTERM
+func ResolveStopSignal(imageName, stopSignal string) (string, error) {
+	if stopSignal == "" {
+		if manifest, _, err := loadImageManifest(imageName); err == nil {
+			stopSignal = manifest.StopSignal
+		}
+	}
+	if stopSignal == "" {
+		return defaultStopSignal, nil
+	}
+	if _, err := ParseSignal(stopSignal); err != nil {
+		return "", err
+	}
+
+	return stopSignal, nil
+}
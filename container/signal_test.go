@@ -0,0 +1,31 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"SIGKILL": syscall.SIGKILL,
+		"KILL":    syscall.SIGKILL,
+		"term":    syscall.SIGTERM,
+		"SigHup":  syscall.SIGHUP,
+		"9":       syscall.SIGKILL,
+		"34":      syscall.Signal(34),
+	}
+	for s, expected := range cases {
+		sig, err := ParseSignal(s)
+		if err != nil {
+			t.Fatalf("ParseSignal(%q): %v", s, err)
+		}
+		if sig != expected {
+			t.Errorf("ParseSignal(%q) = %d, expected %d", s, sig, expected)
+		}
+	}
+	for _, s := range []string{"", "0", "65", "-1", "SIGFOO"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Errorf("ParseSignal(%q) expected error", s)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// 统计目录占用的空间，硬链接只计算一次
+func dirSize(dirPath string) int64 {
+	var size int64
+	inodes := make(map[uint64]bool)
+	_ = filepath.Walk(dirPath, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+			if inodes[stat.Ino] {
+				return nil
+			}
+			inodes[stat.Ino] = true
+		}
+		size += fi.Size()
+		return nil
+	})
+
+	return size
+}
+
+// 将字节数转换为易读的格式，如 1.5MB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
@@ -0,0 +1,130 @@
+/*
+	合并镜像层，将镜像中的若干层合并为一层并生成新的镜像，原镜像保留
+	合并时按顺序解压各层并处理白障文件，合并范围之下还有其它层时保留白障文件
+*/
+
+package container
+
+import (
+	"docker-go/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SquashImage 将镜像从 from 层开始到最上层的所有层合并为一层，保存为新镜像 newImageName
+// from 为层的摘要(可以是前缀)或序号，为空时合并所有层
+func SquashImage(imageName, from, newImageName string) error {
+	if newImageName == "" {
+		newImageName = fmt.Sprintf("%s-squashed", imageName)
+	}
+	if err := validateImageName(newImageName); err != nil {
+		return err
+	}
+	if imageExists(newImageName) {
+		return fmt.Errorf("image %s already exists", newImageName)
+	}
+	manifest, err := getImageManifest(imageName)
+	if err != nil {
+		logrus.Errorf("get image manifest, image: %s, err: %v", imageName, err)
+		return err
+	}
+	start, err := findLayer(manifest, from)
+	if err != nil {
+		return err
+	}
+	if err = verifyImageLayers(manifest); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(common.ImageLayerPath, os.ModePerm); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(common.ImageLayerPath, "squash-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, layer := range manifest.Layers[start:] {
+		if err = applyLayer(tmpDir, layerBlobPath(manifest, layer), start > 0); err != nil {
+			logrus.Errorf("apply layer %s, err: %v", layer.Digest, err)
+			return err
+		}
+	}
+	squashed, err := saveLayerDir(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	var layers []*ImageLayer
+	for _, layer := range manifest.Layers[:start] {
+		if err = ensureLayerBlob(manifest, layer); err != nil {
+			logrus.Errorf("save layer %s, err: %v", layer.Digest, err)
+			return err
+		}
+		layers = append(layers, layer)
+	}
+	newManifest := &ImageManifest{
+		Name:    newImageName,
+		Layers:  append(layers, squashed),
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+
+		StopSignal: manifest.StopSignal,
+	}
+	if _, err = saveImageManifest(newManifest); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "squashed %d layers of %s into %s, new image %s\n",
+		len(manifest.Layers)-start, imageName, squashed.Digest, newImageName)
+
+	return nil
+}
+
+// ImageHistory 打印镜像的各层，最上层在前
+func ImageHistory(imageName string) error {
+	manifest, err := getImageManifest(imageName)
+	if err != nil {
+		logrus.Errorf("get image manifest, image: %s, err: %v", imageName, err)
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 2, ' ', 0)
+	_, _ = fmt.Fprint(w, "INDEX\tDIGEST\tSIZE\n")
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		layer := manifest.Layers[i]
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", i, layer.Digest, humanSize(layer.Size))
+	}
+
+	return w.Flush()
+}
+
+// 根据摘要前缀或序号找到层的位置
+func findLayer(manifest *ImageManifest, from string) (int, error) {
+	if from == "" {
+		return 0, nil
+	}
+	if i, err := strconv.Atoi(from); err == nil {
+		if i < 0 || i >= len(manifest.Layers) {
+			return 0, fmt.Errorf("layer index %d out of range", i)
+		}
+		return i, nil
+	}
+	found := -1
+	for i, layer := range manifest.Layers {
+		if strings.HasPrefix(layer.Digest, from) || strings.HasPrefix(strings.TrimPrefix(layer.Digest, "sha256:"), from) {
+			if found >= 0 {
+				return 0, fmt.Errorf("layer %s is ambiguous", from)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return 0, fmt.Errorf("layer %s not found in image %s", from, manifest.Name)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,216 @@
+/*
+	start 重新运行已经停止的容器，restart 先停止再启动，wait 等待容器退出
+	容器停止后读写层和 config.json 都保留着，start 按照 config.json 中记录的 InitConfig 重新启动 init 进程:
+	1. 读写层是 loop 设备且已经卸载时重新挂载，不再格式化
+	2. aufs 挂载点已经卸载时重新挂载
+	3. 命名数据卷重新向驱动申请挂载
+	重新启动的容器总是由 shim 在后台运行，通过 attach 连接
+*/
+
+package container
+
+import (
+	"docker-go/cgroups/subsystem"
+	"docker-go/common"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 等待进程退出时，不支持 pidfd 的内核轮询的间隔
+const waitPollInterval = 100 * time.Millisecond
+
+// PrepareStart 检查容器是否可以启动，恢复容器的工作空间，并保存 shim 启动容器需要的信息
+func PrepareStart(containerName string) (*ContainerInfo, error) {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if isContainerRunning(info) {
+		return nil, fmt.Errorf("container %s is already running", containerName)
+	}
+	if info.Config == nil {
+		return nil, fmt.Errorf("container %s has no recorded config, it can not be started", containerName)
+	}
+	if err = restoreWorkSpace(info); err != nil {
+		return nil, fmt.Errorf("restore work space of %s: %v", containerName, err)
+	}
+	if info.Resources == nil {
+		info.Resources = &subsystem.ResourceConfig{}
+	}
+	// shim 按照保存的信息启动容器
+	if err = saveContainerInfo(info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// 恢复已经停止的容器的工作空间，读写层必须还在
+func restoreWorkSpace(info *ContainerInfo) error {
+	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, info.Name)
+	if _, err := os.Stat(writeLayerPath); err != nil {
+		return fmt.Errorf("write layer: %v", err)
+	}
+	// 1. 重新挂载 loop 设备，保留其中的内容
+	mounted, err := isMountPoint(writeLayerPath)
+	if err != nil {
+		return err
+	}
+	image := writeLayerPath + loopbackImage
+	if _, err = os.Stat(image); err == nil && !mounted {
+		if err = mountLoopbackImage(image, writeLayerPath); err != nil {
+			return err
+		}
+	}
+	// 2. 重新挂载 aufs
+	mntPath := path.Join(common.MntPath, info.Name)
+	if mounted, err = isMountPoint(mntPath); err != nil {
+		return err
+	}
+	if !mounted {
+		if err = createReadOnlyLayer(info.Image); err != nil {
+			return err
+		}
+		if err = CreateMountPoint(info.Name, info.Image); err != nil {
+			return err
+		}
+	}
+
+	// 3. 准备数据卷
+	return prepareVolumes(info.Name, info.Config.Volumes)
+}
+
+// 判断 p 是否为挂载点
+func isMountPoint(p string) (bool, error) {
+	mountPoint, err := mountPointOf(p)
+	if err != nil {
+		return false, err
+	}
+
+	return mountPoint == path.Clean(p), nil
+}
+
+// SetContainerRunning 容器启动后，记录 init 进程和 shim 进程，前台运行的容器没有 shim，shimPid 为 0
+func SetContainerRunning(containerName string, pid, shimPid int) error {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	info.Pid = strconv.Itoa(pid)
+	info.ShimPid = ""
+	if shimPid > 0 {
+		info.ShimPid = strconv.Itoa(shimPid)
+	}
+	info.Status = common.Running
+	info.ExitCode = 0
+	info.ExitSignal = ""
+	info.OOMKilled = false
+
+	return saveContainerInfo(info)
+}
+
+// 将容器信息写回 config.json
+func saveContainerInfo(info *ContainerInfo) error {
+	bs, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	fileName := path.Join(common.DefaultContainerInfoPath, info.Name, common.ContainerInfoFileName)
+
+	return ioutil.WriteFile(fileName, bs, 0644)
+}
+
+// 容器的 init 进程是否还在运行，容器在后台退出时状态不会更新，需要检查进程
+func isContainerRunning(info *ContainerInfo) bool {
+	if info.Status != common.Running || info.Pid == "" {
+		return false
+	}
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil {
+		return false
+	}
+
+	return processAlive(pid)
+}
+
+// 进程存在且不是僵尸进程
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+	bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// 进程名可能包含空格和括号，状态在最后一个 ) 之后
+	stat := string(bs)
+	i := strings.LastIndex(stat, ")")
+
+	return i < 0 || i+2 >= len(stat) || stat[i+2] != 'Z'
+}
+
+// 等待进程退出，timeout 小于 0 时一直等待，超时返回 false
+// 进程不是当前进程的子进程，不能 wait，优先使用 pidfd，内核不支持时轮询
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if fd, err := unix.PidfdOpen(pid, 0); err == nil {
+		defer unix.Close(fd)
+		for {
+			ms := -1
+			if timeout >= 0 {
+				ms = int(time.Until(deadline) / time.Millisecond)
+				if ms < 0 {
+					ms = 0
+				}
+			}
+			n, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, ms)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				break
+			}
+			return n > 0
+		}
+	}
+	for processAlive(pid) {
+		if timeout >= 0 && time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(waitPollInterval)
+	}
+
+	return true
+}
+
+// WaitContainer 等待容器退出，返回退出码
+func WaitContainer(containerName string) (int, error) {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return -1, err
+	}
+	if isContainerRunning(info) {
+		pid, _ := strconv.Atoi(info.Pid)
+		waitProcessExit(pid, -1)
+	}
+	// 退出码由 shim 在容器退出后记录
+	if shimPid, ok := shimOf(info); ok {
+		waitProcessExit(shimPid, -1)
+	}
+	if info, err = getContainerInfo(containerName); err != nil {
+		return -1, fmt.Errorf("container %s was removed", containerName)
+	}
+
+	return info.ExitCode, nil
+}
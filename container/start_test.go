@@ -0,0 +1,31 @@
+package container
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWaitProcessExit(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	pid := cmd.Process.Pid
+	if !processAlive(pid) {
+		t.Fatal("expected process to be alive")
+	}
+	if waitProcessExit(pid, 100*time.Millisecond) {
+		t.Fatal("expected wait to time out")
+	}
+
+	// 没有被回收的僵尸进程也算已经退出
+	_ = cmd.Process.Kill()
+	if !waitProcessExit(pid, 5*time.Second) {
+		t.Fatal("expected process to exit")
+	}
+	if processAlive(pid) {
+		t.Error("expected zombie process not to be alive")
+	}
+}
@@ -1,37 +1,150 @@
 package container
 
 import (
+	"docker-go/cgroups"
 	"docker-go/common"
-	"encoding/json"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"path"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
-// 停止容器
-func StopContainer(containerName string) {
+// kill 之后等待容器退出的时间，超过时容器状态保持不变
+const killWaitTimeout = time.Second
+
+// CgroupPath 容器的 cgroup 路径
+func CgroupPath(containerName string) string {
+	return path.Join(common.CgroupRootPath, containerName)
+}
+
+// StopContainer 向容器发送停止信号，timeout 内没有退出时杀死容器 cgroup 中的所有进程，等待容器退出后修改容器状态
+// timeout 小于 0 时一直等待
+func StopContainer(containerName string, timeout time.Duration) error {
 	info, err := getContainerInfo(containerName)
 	if err != nil {
-		logrus.Errorf("get container info, err: %v", err)
-		return
+		return err
 	}
-	if info.Pid != "" {
+	if isContainerRunning(info) {
 		pid, _ := strconv.Atoi(info.Pid)
-		// 杀死进程
-		if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
-			logrus.Errorf("stop container, pid: %d, err: %v", pid, err)
-			return
+		stopSignal := info.StopSignal
+		if stopSignal == "" {
+			stopSignal = defaultStopSignal
 		}
-		// 修改容器状态
-		info.Status = common.Stop
-		info.Pid = ""
-		bs, _ := json.Marshal(info)
-		fileName := path.Join(common.DefaultContainerInfoPath, containerName, common.ContainerInfoFileName)
-		err := ioutil.WriteFile(fileName, bs, 0622)
+		sig, err := ParseSignal(stopSignal)
 		if err != nil {
-			logrus.Errorf("write container config.json, err: %v", err)
+			return err
+		}
+		if err = syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("stop container, pid: %d, err: %v", pid, err)
+		}
+		if !waitProcessExit(pid, timeout) {
+			logrus.Infof("container %s did not exit in %v, kill it", containerName, timeout)
+			if err = killContainer(containerName, pid); err != nil {
+				return err
+			}
+			waitProcessExit(pid, -1)
+		}
+	}
+
+	return containerExited(info)
+}
+
+// KillContainer 向容器的 init 进程发送信号，容器退出后修改容器状态
+func KillContainer(containerName, signal string) error {
+	sig, err := ParseSignal(signal)
+	if err != nil {
+		return err
+	}
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !isContainerRunning(info) {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	pid, _ := strconv.Atoi(info.Pid)
+	if sig == syscall.SIGKILL {
+		err = killContainer(containerName, pid)
+	} else {
+		err = syscall.Kill(pid, sig)
+	}
+	if err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("kill container, pid: %d, err: %v", pid, err)
+	}
+	// 信号不一定让容器退出，只有进程确实退出了才修改状态
+	if !waitProcessExit(pid, killWaitTimeout) {
+		return nil
+	}
+
+	return containerExited(info)
+}
+
+// 容器的 init 进程退出后，后台容器等待 shim 记录退出状态，前台容器或者 shim 已经不在时直接修改状态
+func containerExited(info *ContainerInfo) error {
+	if shimPid, ok := shimOf(info); ok {
+		waitProcessExit(shimPid, -1)
+		if latest, err := getContainerInfo(info.Name); err == nil {
+			info = latest
+		}
+	}
+	if info.Status != common.Running {
+		return nil
+	}
+
+	return markContainerStopped(info)
+}
+
+// 获取容器还在运行的 shim 进程，通过命令行确认 pid 没有被其他进程复用
+func shimOf(info *ContainerInfo) (int, bool) {
+	pid, err := strconv.Atoi(info.ShimPid)
+	if err != nil || pid <= 0 || !processAlive(pid) {
+		return 0, false
+	}
+	bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return 0, false
+	}
+	args := strings.Split(strings.TrimRight(string(bs), "\x00"), "\x00")
+	if len(args) != 3 || args[1] != "shim" || args[2] != info.Name {
+		return 0, false
+	}
+
+	return pid, true
+}
+
+// 杀死容器 cgroup 中的所有进程，init 进程被杀死后容器 pid namespace 中的其他进程也会被内核杀死
+func killContainer(containerName string, pid int) error {
+	if err := cgroups.NewCGroupManager(CgroupPath(containerName)).Kill(syscall.SIGKILL); err != nil {
+		logrus.Errorf("kill cgroup of %s, err: %v", containerName, err)
+	}
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+
+	return nil
+}
+
+// 容器停止后，通知数据卷驱动容器不再使用数据卷，并修改容器状态
+func markContainerStopped(info *ContainerInfo) error {
+	releaseVolumes(info.volumes(), info.Name)
+	info.Status = common.Stop
+	info.Pid = ""
+
+	return saveContainerInfo(info)
+}
+
+// 没有 shim 的容器退出后状态不会自动更新，读取时检查 init 进程和 shim 是否还在
+func refreshContainerStatus(info *ContainerInfo) {
+	if _, ok := shimOf(info); ok {
+		return
+	}
+	if info.Status == common.Running && !isContainerRunning(info) {
+		if err := markContainerStopped(info); err != nil {
+			logrus.Errorf("update status of %s, err: %v", info.Name, err)
 		}
 	}
 }
@@ -0,0 +1,123 @@
+/*
+	伪终端，-ti 时为容器中的进程分配一对 pty
+	slave 作为进程的标准输入输出和控制终端，master 留在 docker-go 中，与用户的终端互相转发
+	用户的终端设为 raw 模式，按键(包括 ctrl-c)原样交给容器中的终端处理，退出时恢复
+	用户终端窗口大小改变时(SIGWINCH)同步到 pty
+*/
+
+package container
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// 打开 ptmxPath 分配一对 pty，返回 master 和 slave
+// ptmxPath 所在的 devpts 实例决定了 slave 出现在哪个 /dev/pts 中
+func openPty(ptmxPath string) (*os.File, *os.File, error) {
+	master, err := os.OpenFile(ptmxPath, os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open %s: %v", ptmxPath, err)
+	}
+	fd := int(master.Fd())
+	// 解锁 slave
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("unlock pty: %v", err)
+	}
+	// 通过 master 直接打开 slave，不依赖 /dev/pts 的挂载位置
+	slaveFd, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.TIOCGPTPEER, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC)
+	if errno == 0 {
+		return master, os.NewFile(slaveFd, "pty slave"), nil
+	}
+	// 内核不支持 TIOCGPTPEER(4.13 之前)时按编号打开
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("get pty number: %v", err)
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
+
+// IsTerminal 判断 f 是否为终端
+func IsTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// 将终端设为 raw 模式，返回恢复原来设置的函数
+func setRawTerminal(fd int) (func(), error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	old := *termios
+	// 与 cfmakeraw 相同
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = unix.IoctlSetTermios(fd, unix.TCSETS, &old)
+	}, nil
+}
+
+// 将终端 fd 的窗口大小设置到 pty
+func resizePty(master *os.File, fd int) error {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+
+	return unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+// 设置 pty 的初始窗口大小，并在用户终端大小改变时同步，返回停止同步的函数
+func handleResize(master *os.File, fd int) func() {
+	_ = resizePty(master, fd)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	go func() {
+		for range sigs {
+			_ = resizePty(master, fd)
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
+
+// 在用户终端和 pty master 之间转发数据，返回的 channel 在容器的输出读完后关闭
+func proxyPty(master *os.File, stdin io.Reader, stdout io.Writer) <-chan struct{} {
+	go func() {
+		_, _ = io.Copy(master, stdin)
+	}()
+	done := make(chan struct{})
+	go func() {
+		// slave 全部关闭后读取 master 返回 EIO
+		_, _ = io.Copy(stdout, master)
+		close(done)
+	}()
+
+	return done
+}
@@ -0,0 +1,248 @@
+/*
+	tmpfs 挂载，数据只保存在内存中，容器退出后即消失
+	--tmpfs 的格式为 容器路径[:选项]，选项之间用逗号分隔，例如 /run:size=64m,mode=1777,noexec
+	--mount 的格式为 type=tmpfs,destination=容器路径[,tmpfs-size=64m][,tmpfs-mode=1777][,noexec][,readonly]
+	tmpfs 在容器的 init 进程 pivot_root 之后挂载
+*/
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// TmpfsMount tmpfs 挂载
+type TmpfsMount struct {
+	Destination string `json:"destination"`    // 容器内的路径
+	Size        int64  `json:"size,omitempty"` // 大小上限，单位为字节，0 表示使用内核默认值(内存的一半)
+	Mode        uint32 `json:"mode,omitempty"` // 挂载点的权限，0 表示使用内核默认值 1777
+	NoExec      bool   `json:"noexec"`
+	ReadOnly    bool   `json:"readOnly"`
+}
+
+// ParseTmpfsMounts 解析 --tmpfs 和 --mount 参数，容器内路径不能重复
+func ParseTmpfsMounts(tmpfsSpecs, mountSpecs []string) ([]*TmpfsMount, error) {
+	var mounts []*TmpfsMount
+	for _, spec := range tmpfsSpecs {
+		mount, err := parseTmpfs(spec)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+	for _, spec := range mountSpecs {
+		mount, err := parseMount(spec)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+	destinations := make(map[string]bool)
+	for _, mount := range mounts {
+		if destinations[mount.Destination] {
+			return nil, fmt.Errorf("duplicate mount point %s", mount.Destination)
+		}
+		destinations[mount.Destination] = true
+	}
+
+	return mounts, nil
+}
+
+// CheckMountPoints 数据卷和 tmpfs 不能挂载到同一个路径
+func CheckMountPoints(volumes []*Volume, tmpfs []*TmpfsMount) error {
+	destinations := make(map[string]bool)
+	for _, volume := range volumes {
+		destinations[volume.Destination] = true
+	}
+	for _, mount := range tmpfs {
+		if destinations[mount.Destination] {
+			return fmt.Errorf("duplicate mount point %s", mount.Destination)
+		}
+	}
+
+	return nil
+}
+
+// 解析 --tmpfs 参数
+func parseTmpfs(spec string) (*TmpfsMount, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	mount := &TmpfsMount{}
+	if err := mount.setDestination(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid tmpfs %q: %v", spec, err)
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		return mount, nil
+	}
+	for _, option := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(option, "=", 2)
+		var err error
+		switch {
+		case kv[0] == "size" && len(kv) == 2:
+			err = mount.setSize(kv[1])
+		case kv[0] == "mode" && len(kv) == 2:
+			err = mount.setMode(kv[1])
+		case len(kv) == 1:
+			err = mount.setFlag(kv[0])
+		default:
+			err = fmt.Errorf("unknown option %q", option)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tmpfs %q: %v", spec, err)
+		}
+	}
+
+	return mount, nil
+}
+
+// 解析 --mount 参数，目前只支持 tmpfs
+func parseMount(spec string) (*TmpfsMount, error) {
+	mount := &TmpfsMount{}
+	mountType := ""
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		var err error
+		switch kv[0] {
+		case "type":
+			if len(kv) == 2 {
+				mountType = kv[1]
+			}
+		case "destination", "dst", "target":
+			if len(kv) == 2 {
+				err = mount.setDestination(kv[1])
+			}
+		case "tmpfs-size":
+			if len(kv) == 2 {
+				err = mount.setSize(kv[1])
+			}
+		case "tmpfs-mode":
+			if len(kv) == 2 {
+				err = mount.setMode(kv[1])
+			}
+		case "readonly", "ro":
+			if len(kv) == 1 || kv[1] == "true" || kv[1] == "1" {
+				mount.ReadOnly = true
+			}
+		default:
+			if len(kv) == 1 {
+				err = mount.setFlag(kv[0])
+			} else {
+				err = fmt.Errorf("unknown option %q", field)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount %q: %v", spec, err)
+		}
+	}
+	if mountType != "tmpfs" {
+		return nil, fmt.Errorf("invalid mount %q: only type=tmpfs is supported", spec)
+	}
+	if mount.Destination == "" {
+		return nil, fmt.Errorf("invalid mount %q: missing destination", spec)
+	}
+
+	return mount, nil
+}
+
+func (t *TmpfsMount) setDestination(destination string) error {
+	destination = path.Clean(destination)
+	if !path.IsAbs(destination) || destination == "/" {
+		return fmt.Errorf("container path must be absolute and not /")
+	}
+	t.Destination = destination
+	return nil
+}
+
+func (t *TmpfsMount) setSize(value string) error {
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	t.Size = size
+	return nil
+}
+
+func (t *TmpfsMount) setMode(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 07777 {
+		return fmt.Errorf("invalid mode %q", value)
+	}
+	t.Mode = uint32(mode)
+	return nil
+}
+
+func (t *TmpfsMount) setFlag(flag string) error {
+	switch flag {
+	case "noexec":
+		t.NoExec = true
+	case "exec":
+		t.NoExec = false
+	case "ro":
+		t.ReadOnly = true
+	case "rw":
+		t.ReadOnly = false
+	default:
+		return fmt.Errorf("unknown option %q", flag)
+	}
+	return nil
+}
+
+// 在容器中挂载 tmpfs，必须在 pivot_root 之后调用
+func mountTmpfs(mounts []*TmpfsMount) error {
+	for _, mount := range mounts {
+		if err := os.MkdirAll(mount.Destination, 0755); err != nil {
+			return fmt.Errorf("mkdir tmpfs mount point %s: %v", mount.Destination, err)
+		}
+		flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+		if mount.NoExec {
+			flags |= syscall.MS_NOEXEC
+		}
+		if mount.ReadOnly {
+			flags |= syscall.MS_RDONLY
+		}
+		var options []string
+		if mount.Size > 0 {
+			options = append(options, fmt.Sprintf("size=%d", mount.Size))
+		}
+		if mount.Mode != 0 {
+			options = append(options, fmt.Sprintf("mode=%o", mount.Mode))
+		}
+		if err := syscall.Mount("tmpfs", mount.Destination, "tmpfs", flags, strings.Join(options, ",")); err != nil {
+			return fmt.Errorf("mount tmpfs %s: %v", mount.Destination, err)
+		}
+	}
+
+	return nil
+}
+
+// 解析带单位的大小，如 512k、64m、10G，单位为 1024 进制，没有单位时为字节
+func parseSize(value string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "b")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
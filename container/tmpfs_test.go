@@ -0,0 +1,38 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTmpfsMounts(t *testing.T) {
+	mounts, err := ParseTmpfsMounts(
+		[]string{"/run:size=64m,mode=1777,noexec", "/scratch"},
+		[]string{"type=tmpfs,destination=/cache,tmpfs-size=1g,tmpfs-mode=700,readonly"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*TmpfsMount{
+		{Destination: "/run", Size: 64 << 20, Mode: 01777, NoExec: true},
+		{Destination: "/scratch"},
+		{Destination: "/cache", Size: 1 << 30, Mode: 0700, ReadOnly: true},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("mounts = %+v, expected %+v", mounts, expected)
+	}
+
+	invalid := [][2][]string{
+		{{"relative"}, nil},
+		{{"/run:size=abc"}, nil},
+		{{"/run:mode=999"}, nil},
+		{{"/run", "/run/"}, nil},
+		{nil, {"type=bind,destination=/run"}},
+		{nil, {"type=tmpfs"}},
+	}
+	for _, specs := range invalid {
+		if _, err = ParseTmpfsMounts(specs[0], specs[1]); err == nil {
+			t.Errorf("ParseTmpfsMounts(%q, %q) expected error", specs[0], specs[1])
+		}
+	}
+}
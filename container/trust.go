@@ -0,0 +1,333 @@
+/*
+	镜像签名与信任策略
+	使用 ed25519 对镜像清单签名，签名保存在 /root/images/镜像名/signatures/公钥ID.json
+	信任策略文件 /etc/docker-go/policy.json 将镜像名映射到可信的公钥，例如:
+	{
+		"default": "reject",
+		"images": {
+			"busybox": ["/etc/docker-go/keys/busybox.pub"],
+			"prod-*": ["/etc/docker-go/keys/prod.pub"]
+		}
+	}
+	镜像名支持通配符，没有匹配的镜像按 default 处理，策略文件不存在时不做校验
+*/
+
+package container
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"docker-go/common"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// 信任策略中没有匹配镜像时的处理方式
+const (
+	TrustAccept = "accept"
+	TrustReject = "reject"
+)
+
+// TrustPolicy 信任策略
+type TrustPolicy struct {
+	Default string              `json:"default"` // accept 或 reject，默认为 accept
+	Images  map[string][]string `json:"images"`  // 镜像名 -> 公钥文件列表
+}
+
+// ImageSignature 镜像签名
+type ImageSignature struct {
+	KeyId     string `json:"keyId"`     // 签名公钥的ID
+	Digest    string `json:"digest"`    // 被签名的镜像清单摘要
+	Signature string `json:"signature"` // base64 编码的签名
+}
+
+// GenerateSigningKey 生成 ed25519 密钥对，写入 prefix.key 和 prefix.pub
+func GenerateSigningKey(prefix string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	privatePem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes})
+	if err = ioutil.WriteFile(prefix+".key", privatePem, 0600); err != nil {
+		logrus.Errorf("write private key, err: %v", err)
+		return err
+	}
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes})
+	if err = ioutil.WriteFile(prefix+".pub", publicPem, 0644); err != nil {
+		logrus.Errorf("write public key, err: %v", err)
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "key id: %s\n", keyId(publicKey))
+
+	return nil
+}
+
+// SignImage 使用私钥对镜像清单签名
+// 镜像内容与清单不一致时重新生成清单，旧的签名随之失效
+func SignImage(imageName, keyPath string) error {
+	privateKey, err := loadPrivateKey(keyPath)
+	if err != nil {
+		logrus.Errorf("load private key, path: %s, err: %v", keyPath, err)
+		return err
+	}
+	manifest, bs, err := loadImageManifest(imageName)
+	if err == nil {
+		err = verifyImageLayers(manifest)
+	}
+	if err != nil {
+		// 只有 tar 包导入的镜像可以重新生成清单
+		if _, statErr := os.Stat(imageTarPath(imageName)); statErr != nil {
+			logrus.Errorf("load image manifest, image: %s, err: %v", imageName, err)
+			return err
+		}
+		_ = os.RemoveAll(imageSignatureDir(imageName))
+		if _, bs, err = createImageManifest(imageName); err != nil {
+			return err
+		}
+	}
+
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	signature := &ImageSignature{
+		KeyId:     keyId(publicKey),
+		Digest:    manifestDigest(bs),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, bs)),
+	}
+	dir := imageSignatureDir(imageName)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		logrus.Errorf("mkdir signature dir: %s, err: %v", dir, err)
+		return err
+	}
+	sigBytes, _ := json.Marshal(signature)
+	sigPath := path.Join(dir, fmt.Sprintf("%s.json", signature.KeyId))
+	if err = ioutil.WriteFile(sigPath, sigBytes, 0644); err != nil {
+		logrus.Errorf("write image signature, path: %s, err: %v", sigPath, err)
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "signed %s %s with key %s\n", imageName, signature.Digest, signature.KeyId)
+
+	return nil
+}
+
+// VerifyImage 按照信任策略校验镜像签名和各层的内容，不满足策略的镜像不允许运行
+func VerifyImage(imageName string) error {
+	manifest, _, err := trustedManifest(imageName)
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	return verifyImageLayers(manifest)
+}
+
+// 按照信任策略校验镜像清单的签名，返回通过校验的清单及其原始内容
+// 策略不要求该镜像签名时返回 nil，各层的内容由使用方根据清单中的摘要校验
+func trustedManifest(imageName string) (*ImageManifest, []byte, error) {
+	policy, err := loadTrustPolicy(common.DefaultTrustPolicyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		logrus.Errorf("load trust policy, err: %v", err)
+		return nil, nil, err
+	}
+	keyPaths, ok := policy.keysFor(imageName)
+	if !ok {
+		if policy.Default == TrustReject {
+			return nil, nil, fmt.Errorf("image %s is not allowed by trust policy", imageName)
+		}
+		return nil, nil, nil
+	}
+
+	var keys []ed25519.PublicKey
+	for _, keyPath := range keyPaths {
+		key, err := loadPublicKey(keyPath)
+		if err != nil {
+			logrus.Errorf("load public key, path: %s, err: %v", keyPath, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	manifest, bs, err := loadImageManifest(imageName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("image %s is not signed", imageName)
+		}
+		return nil, nil, err
+	}
+	signatures, err := loadImageSignatures(imageName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signatures) == 0 {
+		return nil, nil, fmt.Errorf("image %s is not signed", imageName)
+	}
+	if err = verifySignatures(bs, signatures, keys); err != nil {
+		return nil, nil, fmt.Errorf("image %s: %v", imageName, err)
+	}
+
+	return manifest, bs, nil
+}
+
+// 只要有一个签名能被可信公钥验证即通过
+func verifySignatures(manifest []byte, signatures []*ImageSignature, keys []ed25519.PublicKey) error {
+	digest := manifestDigest(manifest)
+	for _, signature := range signatures {
+		if signature.Digest != digest {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(signature.Signature)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if keyId(key) == signature.KeyId && ed25519.Verify(key, manifest, sig) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no valid signature from a trusted key")
+}
+
+// 精确匹配优先，其次使用最长的通配符匹配
+func (p *TrustPolicy) keysFor(imageName string) ([]string, bool) {
+	if keys, ok := p.Images[imageName]; ok {
+		return keys, true
+	}
+	var patterns []string
+	for pattern := range p.Images {
+		if matched, _ := path.Match(pattern, imageName); matched {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, false
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	return p.Images[patterns[0]], true
+}
+
+func loadTrustPolicy(policyPath string) (*TrustPolicy, error) {
+	bs, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	policy := &TrustPolicy{}
+	if err = json.Unmarshal(bs, policy); err != nil {
+		return nil, err
+	}
+	if policy.Default == "" {
+		policy.Default = TrustAccept
+	}
+	if policy.Default != TrustAccept && policy.Default != TrustReject {
+		return nil, fmt.Errorf("invalid trust policy default %q", policy.Default)
+	}
+
+	return policy, nil
+}
+
+func loadImageSignatures(imageName string) ([]*ImageSignature, error) {
+	files, err := ioutil.ReadDir(imageSignatureDir(imageName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var signatures []*ImageSignature
+	for _, file := range files {
+		bs, err := ioutil.ReadFile(path.Join(imageSignatureDir(imageName), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		signature := &ImageSignature{}
+		if err = json.Unmarshal(bs, signature); err != nil {
+			logrus.Errorf("unmarshal image signature, file: %s, err: %v", file.Name(), err)
+			continue
+		}
+		signatures = append(signatures, signature)
+	}
+
+	return signatures, nil
+}
+
+func loadPrivateKey(keyPath string) (ed25519.PrivateKey, error) {
+	block, err := readPemBlock(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", keyPath)
+	}
+
+	return privateKey, nil
+}
+
+func loadPublicKey(keyPath string) (ed25519.PublicKey, error) {
+	block, err := readPemBlock(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", keyPath)
+	}
+
+	return publicKey, nil
+}
+
+func readPemBlock(keyPath string) (*pem.Block, error) {
+	bs, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data in %s", keyPath)
+	}
+
+	return block, nil
+}
+
+// 公钥ID为公钥 sha256 摘要的前16位
+func keyId(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func imageSignatureDir(imageName string) string {
+	return path.Join(common.ImageStorePath, imageName, common.ImageSignatureDirName)
+}
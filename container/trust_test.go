@@ -0,0 +1,56 @@
+package container
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifySignatures(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	manifest := []byte(`{"name":"busybox","layers":[{"digest":"sha256:00","size":1}]}`)
+	signature := &ImageSignature{
+		KeyId:     keyId(publicKey),
+		Digest:    manifestDigest(manifest),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, manifest)),
+	}
+
+	if err := verifySignatures(manifest, []*ImageSignature{signature}, []ed25519.PublicKey{otherKey, publicKey}); err != nil {
+		t.Errorf("verify signature, err: %v", err)
+	}
+	if err := verifySignatures(manifest, []*ImageSignature{signature}, []ed25519.PublicKey{otherKey}); err == nil {
+		t.Errorf("signature verified with untrusted key")
+	}
+	tampered := []byte(`{"name":"busybox","layers":[{"digest":"sha256:01","size":1}]}`)
+	if err := verifySignatures(tampered, []*ImageSignature{signature}, []ed25519.PublicKey{publicKey}); err == nil {
+		t.Errorf("signature verified with tampered manifest")
+	}
+}
+
+func TestTrustPolicyKeysFor(t *testing.T) {
+	policy := &TrustPolicy{Images: map[string][]string{
+		"busybox":  {"busybox.pub"},
+		"prod-*":   {"prod.pub"},
+		"prod-db*": {"db.pub"},
+	}}
+	cases := map[string]string{
+		"busybox":    "busybox.pub",
+		"prod-web":   "prod.pub",
+		"prod-db-01": "db.pub",
+		"ubuntu":     "",
+	}
+	for imageName, expected := range cases {
+		keys, ok := policy.keysFor(imageName)
+		if expected == "" {
+			if ok {
+				t.Errorf("%s: unexpected keys %v", imageName, keys)
+			}
+			continue
+		}
+		if !ok || keys[0] != expected {
+			t.Errorf("%s: keys = %v, expected %s", imageName, keys, expected)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+	容器中运行用户命令的用户，-u 的格式为 用户[:组]，用户和组可以是名字或者数字 id
+	名字通过容器中的 /etc/passwd 和 /etc/group 查找，必须在 pivot_root 之后调用
+	数字 id 在 /etc/passwd 中不存在时也可以使用，此时组默认为 0
+*/
+
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 容器中的用户和组文件，测试时替换为 testdata 中的文件
+var (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// 容器中的用户
+type execUser struct {
+	uid    int
+	gid    int
+	groups []int
+	home   string
+}
+
+// 解析 -u 参数，查找用户的 uid、gid、附加组和家目录
+func lookupUser(spec string) (*execUser, error) {
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+	user := &execUser{home: "/"}
+	passwd, err := readColonFile(passwdPath, 7)
+	if err != nil {
+		return nil, err
+	}
+	uid, uidErr := strconv.Atoi(userPart)
+	found := false
+	for _, fields := range passwd {
+		if fields[0] == userPart || (uidErr == nil && fields[2] == userPart) {
+			user.uid, _ = strconv.Atoi(fields[2])
+			user.gid, _ = strconv.Atoi(fields[3])
+			user.home = fields[5]
+			userPart = fields[0]
+			found = true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil || uid < 0 {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		user.uid = uid
+	}
+
+	groups, err := readColonFile(groupPath, 4)
+	if err != nil {
+		return nil, err
+	}
+	if groupPart != "" {
+		gid, gidErr := strconv.Atoi(groupPart)
+		found = false
+		for _, fields := range groups {
+			if fields[0] == groupPart || (gidErr == nil && fields[2] == groupPart) {
+				user.gid, _ = strconv.Atoi(fields[2])
+				found = true
+				break
+			}
+		}
+		if !found {
+			if gidErr != nil || gid < 0 {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+			user.gid = gid
+		}
+	}
+	// 附加组，指定了组时只使用该组
+	user.groups = []int{user.gid}
+	if groupPart == "" {
+		for _, fields := range groups {
+			gid, err := strconv.Atoi(fields[2])
+			if err != nil || gid == user.gid {
+				continue
+			}
+			for _, member := range strings.Split(fields[3], ",") {
+				if member == userPart {
+					user.groups = append(user.groups, gid)
+					break
+				}
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// 读取 /etc/passwd 这样以冒号分隔的文件，文件不存在时返回空，字段不足 n 个的行忽略
+func readColonFile(fileName string, n int) ([][]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) >= n {
+			lines = append(lines, fields)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// 切换到 spec 指定的用户，返回补充了 HOME 的环境变量，spec 为空时保持 root
+func setUser(spec string, env []string) ([]string, error) {
+	if spec == "" {
+		return env, nil
+	}
+	user, err := lookupUser(spec)
+	if err != nil {
+		return nil, err
+	}
+	// 先设置组，setuid 之后就没有权限了
+	if err = syscall.Setgroups(user.groups); err != nil {
+		return nil, fmt.Errorf("setgroups: %v", err)
+	}
+	if err = syscall.Setgid(user.gid); err != nil {
+		return nil, fmt.Errorf("setgid %d: %v", user.gid, err)
+	}
+	if err = syscall.Setuid(user.uid); err != nil {
+		return nil, fmt.Errorf("setuid %d: %v", user.uid, err)
+	}
+	for _, e := range env {
+		if strings.HasPrefix(e, "HOME=") {
+			return env, nil
+		}
+	}
+
+	return append(env, "HOME="+user.home), nil
+}
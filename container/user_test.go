@@ -0,0 +1,93 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 使用 testdata 中的 passwd 和 group 文件
+func useUserFiles(t *testing.T, passwd, group string) {
+	oldPasswd, oldGroup := passwdPath, groupPath
+	passwdPath, groupPath = passwd, group
+	t.Cleanup(func() {
+		passwdPath, groupPath = oldPasswd, oldGroup
+	})
+}
+
+func TestReadColonFile(t *testing.T) {
+	lines, err := readColonFile(filepath.Join("testdata", "passwd"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fields := range lines {
+		names = append(names, fields[0])
+	}
+	// 注释、空行和字段不足的行被忽略
+	if expected := []string{"root", "daemon", "nginx", "app"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("passwd users = %v, expected %v", names, expected)
+	}
+
+	if lines, err = readColonFile(filepath.Join("testdata", "missing"), 7); err != nil || lines != nil {
+		t.Errorf("missing file = %v, %v, expected nothing", lines, err)
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	useUserFiles(t, filepath.Join("testdata", "passwd"), filepath.Join("testdata", "group"))
+
+	tests := []struct {
+		spec     string
+		expected *execUser
+	}{
+		{"root", &execUser{uid: 0, gid: 0, groups: []int{0}, home: "/root"}},
+		{"app", &execUser{uid: 1000, gid: 1000, groups: []int{1000, 4, 33}, home: "/home/app"}},
+		{"1000", &execUser{uid: 1000, gid: 1000, groups: []int{1000, 4, 33}, home: "/home/app"}},
+		{"nginx", &execUser{uid: 101, gid: 101, groups: []int{101, 33}, home: "/var/cache/nginx"}},
+		{"app:www", &execUser{uid: 1000, gid: 33, groups: []int{33}, home: "/home/app"}},
+		{"app:0", &execUser{uid: 1000, gid: 0, groups: []int{0}, home: "/home/app"}},
+		{"nginx:500", &execUser{uid: 101, gid: 500, groups: []int{500}, home: "/var/cache/nginx"}},
+		// passwd 中没有的数字 id 也可以使用，组默认为 0
+		{"4242", &execUser{uid: 4242, gid: 0, groups: []int{0}, home: "/"}},
+		{"4242:4242", &execUser{uid: 4242, gid: 4242, groups: []int{4242}, home: "/"}},
+	}
+	for _, test := range tests {
+		user, err := lookupUser(test.spec)
+		if err != nil {
+			t.Errorf("lookupUser(%s), err: %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(user, test.expected) {
+			t.Errorf("lookupUser(%s) = %+v, expected %+v", test.spec, user, test.expected)
+		}
+	}
+
+	for _, spec := range []string{"nobody", "broken", "-1", "app:staff", "app:-1"} {
+		if user, err := lookupUser(spec); err == nil {
+			t.Errorf("lookupUser(%s) = %+v, expected error", spec, user)
+		}
+	}
+}
+
+func TestLookupUserWithoutFiles(t *testing.T) {
+	// 镜像中没有 passwd 和 group 时只能使用数字 id
+	dir := t.TempDir()
+	useUserFiles(t, filepath.Join(dir, "passwd"), filepath.Join(dir, "group"))
+	user, err := lookupUser("1000:1000")
+	if err != nil || !reflect.DeepEqual(user, &execUser{uid: 1000, gid: 1000, groups: []int{1000}, home: "/"}) {
+		t.Errorf("lookupUser(1000:1000) = %+v, %v", user, err)
+	}
+	if _, err = lookupUser("root"); err == nil {
+		t.Errorf("lookupUser(root) without passwd expected error")
+	}
+
+	// 文件无法读取时返回错误
+	if err = os.Mkdir(filepath.Join(dir, "passwd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lookupUser("1000"); err == nil {
+		t.Errorf("lookupUser with unreadable passwd expected error")
+	}
+}
@@ -0,0 +1,571 @@
+/*
+	数据卷，-v 参数的格式为 宿主机路径:容器路径[:选项]，选项之间用逗号分隔:
+	ro|rw 读写模式，private|rprivate|slave|rslave|shared|rshared 挂载传播方式，bind|rbind 是否递归挂载
+	宿主机路径不是绝对路径时为命名数据卷，数据保存在 /var/lib/docker-go/volumes/卷名/_data
+	卷的信息保存在同一目录下的 volume.json，引用卷的容器从各容器的 ContainerInfo 中统计
+	命名数据卷不存在时自动创建，第一次使用且卷为空时先将镜像中挂载点下的内容拷贝到卷中，之后不再拷贝
+	使用外部驱动的数据卷由插件管理数据，这里只保存卷的信息，容器启动时向插件获取挂载路径
+*/
+
+package container
+
+import (
+	"crypto/rand"
+	"docker-go/common"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// 数据卷的读写模式
+const (
+	VolumeReadOnly  = "ro"
+	VolumeReadWrite = "rw"
+)
+
+// 命名数据卷的名字
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// 数据卷是否递归挂载，rbind 会同时挂载宿主机目录下的子挂载点
+const (
+	VolumeBind  = "bind"
+	VolumeRBind = "rbind"
+)
+
+// Volume 数据卷
+type Volume struct {
+	Name        string `json:"name,omitempty"`   // 命名数据卷的名字，绑定宿主机目录时为空
+	Driver      string `json:"driver,omitempty"` // 命名数据卷的驱动
+	Source      string `json:"source"`           // 宿主机上的路径
+	Destination string `json:"destination"`      // 容器内的路径
+	ReadOnly    bool   `json:"readOnly"`
+	Propagation string `json:"propagation"` // 挂载传播方式
+	Recursive   bool   `json:"recursive"`   // 是否递归挂载
+}
+
+// VolumeConfig 命名数据卷的信息
+type VolumeConfig struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Mountpoint string            `json:"mountpoint"` // 卷的数据目录，外部驱动的卷由插件提供
+	CreatedAt  string            `json:"createdAt"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Options    map[string]string `json:"options,omitempty"` // 创建时传给驱动的参数
+	Seeded     bool              `json:"seeded,omitempty"`  // 第一次使用时是否已经用镜像中的内容初始化过
+}
+
+// inspect 输出的卷信息，附带引用该卷的容器
+type volumeInspect struct {
+	*VolumeConfig
+	Containers []string `json:"containers"`
+}
+
+// ParseVolume 解析 -v 参数
+func ParseVolume(spec string) (*Volume, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid volume %q, expected host:container[:options]", spec)
+	}
+	volume := &Volume{
+		Destination: path.Clean(parts[1]),
+		Propagation: PropagationRPrivate,
+		Recursive:   true,
+	}
+	if !path.IsAbs(volume.Destination) || volume.Destination == "/" {
+		return nil, fmt.Errorf("invalid volume %q, container path must be absolute and not /", spec)
+	}
+	if len(parts) == 3 {
+		for _, option := range strings.Split(parts[2], ",") {
+			switch option {
+			case VolumeReadOnly:
+				volume.ReadOnly = true
+			case VolumeReadWrite:
+				volume.ReadOnly = false
+			case VolumeBind:
+				volume.Recursive = false
+			case VolumeRBind:
+				volume.Recursive = true
+			default:
+				if _, ok := propagationFlags[option]; !ok {
+					return nil, fmt.Errorf("invalid volume option %q in %q", option, spec)
+				}
+				volume.Propagation = option
+			}
+		}
+	}
+
+	source := parts[0]
+	switch {
+	case path.IsAbs(source):
+		volume.Source = path.Clean(source)
+	case volumeNamePattern.MatchString(source):
+		volume.Name = source
+		volume.Source = namedVolumePath(source)
+	default:
+		return nil, fmt.Errorf("invalid volume %q, host path must be absolute or a volume name", spec)
+	}
+
+	return volume, nil
+}
+
+// ParseVolumes 解析多个 -v 参数，容器内路径不能重复
+func ParseVolumes(specs []string) ([]*Volume, error) {
+	var volumes []*Volume
+	destinations := make(map[string]bool)
+	for _, spec := range specs {
+		volume, err := ParseVolume(spec)
+		if err != nil {
+			return nil, err
+		}
+		if destinations[volume.Destination] {
+			return nil, fmt.Errorf("duplicate mount point %s", volume.Destination)
+		}
+		destinations[volume.Destination] = true
+		volumes = append(volumes, volume)
+	}
+
+	return volumes, nil
+}
+
+// 命名数据卷的数据目录
+func namedVolumePath(name string) string {
+	return path.Join(common.DefaultVolumePath, name, common.VolumeDataDirName)
+}
+
+func (v *Volume) String() string {
+	options := []string{VolumeReadWrite}
+	if v.ReadOnly {
+		options[0] = VolumeReadOnly
+	}
+	if v.Propagation != "" {
+		options = append(options, v.Propagation)
+	}
+	if v.Recursive {
+		options = append(options, VolumeRBind)
+	} else {
+		options = append(options, VolumeBind)
+	}
+	source := v.Source
+	if v.Name != "" {
+		source = v.Name
+	}
+
+	return fmt.Sprintf("%s:%s:%s", source, v.Destination, strings.Join(options, ","))
+}
+
+// CreateVolume 创建命名数据卷，名字为空时随机生成，已存在的卷直接使用
+// driver 为空时使用 local 驱动，opts 为传给驱动的参数
+func CreateVolume(name, driver string, labels, opts []string) error {
+	if name == "" {
+		name = randomVolumeName()
+	}
+	if !volumeNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q", name)
+	}
+	if _, err := createVolume(name, driver, parseKeyValues(labels), parseKeyValues(opts)); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(os.Stdout, name)
+
+	return nil
+}
+
+// ListVolumes 列出所有命名数据卷，quiet 为 true 时只打印卷名
+func ListVolumes(quiet bool) error {
+	volumes := listVolumes()
+	if quiet {
+		for _, volume := range volumes {
+			_, _ = fmt.Fprintln(os.Stdout, volume.Name)
+		}
+		return nil
+	}
+	refs := volumeRefs(listContainerInfos())
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "DRIVER\tVOLUME NAME\tCONTAINERS\tCREATED\n")
+	for _, volume := range volumes {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", volume.Driver, volume.Name, len(refs[volume.Name]), volume.CreatedAt)
+	}
+
+	return w.Flush()
+}
+
+// InspectVolumes 以 json 格式打印数据卷的详细信息
+func InspectVolumes(names []string) error {
+	refs := volumeRefs(listContainerInfos())
+	var result []*volumeInspect
+	var lastErr error
+	for _, name := range names {
+		volume, err := getVolume(name)
+		if err != nil {
+			logrus.Errorf("get volume %s, err: %v", name, err)
+			lastErr = err
+			continue
+		}
+		if volume.Mountpoint == "" {
+			if driver, err := getVolumeDriver(volume.Driver); err == nil {
+				volume.Mountpoint, _ = driver.Path(name)
+			}
+		}
+		containers := refs[name]
+		if containers == nil {
+			containers = []string{}
+		}
+		result = append(result, &volumeInspect{VolumeConfig: volume, Containers: containers})
+	}
+	if len(result) > 0 {
+		bs, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(os.Stdout, string(bs))
+	}
+
+	return lastErr
+}
+
+// RemoveVolumes 删除命名数据卷，仍被容器引用的卷不能删除
+func RemoveVolumes(names []string) error {
+	refs := volumeRefs(listContainerInfos())
+	var failed int
+	for _, name := range names {
+		if _, err := getVolume(name); err != nil {
+			logrus.Errorf("get volume %s, err: %v", name, err)
+			failed++
+			continue
+		}
+		if err := volumeUnused(name, refs); err != nil {
+			logrus.Error(err)
+			failed++
+			continue
+		}
+		if err := removeVolume(name); err != nil {
+			logrus.Errorf("remove volume %s, err: %v", name, err)
+			failed++
+			continue
+		}
+		_, _ = fmt.Fprintln(os.Stdout, name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d volume(s)", failed)
+	}
+
+	return nil
+}
+
+// PruneVolumes 清理没有被任何容器引用的命名数据卷
+func PruneVolumes(opts *PruneOptions) error {
+	return prune(volumePruneItems(listContainerInfos(), opts), opts)
+}
+
+// 没有被 infos 中的容器引用的命名数据卷
+func volumePruneItems(infos []*ContainerInfo, opts *PruneOptions) []pruneItem {
+	refs := volumeRefs(infos)
+	var items []pruneItem
+	for _, volume := range listVolumes() {
+		created, _ := time.ParseInLocation("2006-01-02 15:04:05", volume.CreatedAt, time.Local)
+		if len(refs[volume.Name]) > 0 || !opts.match(created) {
+			continue
+		}
+		name := volume.Name
+		var size int64
+		if volume.Mountpoint != "" {
+			size = dirSize(volume.Mountpoint)
+		}
+		items = append(items, pruneItem{
+			kind: pruneVolume,
+			name: name,
+			path: volume.Mountpoint,
+			size: size,
+			remove: func() error {
+				return removeVolume(name)
+			},
+		})
+	}
+
+	return items
+}
+
+// 通过驱动创建命名数据卷并保存卷的信息，已存在时返回已有的卷
+func createVolume(name, driverName string, labels, opts map[string]string) (*VolumeConfig, error) {
+	if volume, err := getVolume(name); err == nil {
+		if driverName != "" && driverName != volume.Driver {
+			return nil, fmt.Errorf("volume %s already exists with driver %s", name, volume.Driver)
+		}
+		return volume, nil
+	}
+	driver, err := getVolumeDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	if err = driver.Create(name, opts); err != nil {
+		logrus.Errorf("create volume %s with driver %s, err: %v", name, driver.Name(), err)
+		return nil, err
+	}
+	volume := &VolumeConfig{
+		Name:      name,
+		Driver:    driver.Name(),
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if _, ok := driver.(*localDriver); ok {
+		volume.Mountpoint = namedVolumePath(name)
+	}
+	if len(labels) > 0 {
+		volume.Labels = labels
+	}
+	if len(opts) > 0 {
+		volume.Options = opts
+	}
+	volumeDir := path.Join(common.DefaultVolumePath, name)
+	if err = os.MkdirAll(volumeDir, 0755); err != nil {
+		logrus.Errorf("mkdir volume dir: %s, err: %v", volumeDir, err)
+		return nil, err
+	}
+	if err = saveVolume(volume); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// 保存命名数据卷的信息
+func saveVolume(volume *VolumeConfig) error {
+	bs, _ := json.Marshal(volume)
+	if err := ioutil.WriteFile(volumeConfigPath(volume.Name), bs, 0644); err != nil {
+		logrus.Errorf("write volume config, name: %s, err: %v", volume.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// 读取命名数据卷的信息，只有数据目录没有信息文件的卷按目录信息补全
+func getVolume(name string) (*VolumeConfig, error) {
+	if !volumeNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid volume name %q", name)
+	}
+	bs, err := ioutil.ReadFile(volumeConfigPath(name))
+	if err == nil {
+		volume := &VolumeConfig{}
+		if err = json.Unmarshal(bs, volume); err != nil {
+			return nil, err
+		}
+		return volume, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	fi, err := os.Stat(namedVolumePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such volume: %s", name)
+		}
+		return nil, err
+	}
+
+	return &VolumeConfig{
+		Name:       name,
+		Driver:     common.DefaultVolumeDriver,
+		Mountpoint: namedVolumePath(name),
+		CreatedAt:  fi.ModTime().Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// 获取所有命名数据卷，按名字排序
+func listVolumes() []*VolumeConfig {
+	files, err := ioutil.ReadDir(common.DefaultVolumePath)
+	if err != nil {
+		return nil
+	}
+	var volumes []*VolumeConfig
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		volume, err := getVolume(file.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+
+	return volumes
+}
+
+func removeVolume(name string) error {
+	volume, err := getVolume(name)
+	if err != nil {
+		return err
+	}
+	volumeDir := path.Join(common.DefaultVolumePath, name)
+	// 卷目录下还有挂载时不能删除，否则会删除挂载的内容
+	if mounts, err := mountPointsUnder(volumeDir); err != nil {
+		return err
+	} else if len(mounts) > 0 {
+		return fmt.Errorf("volume %s is still mounted at %s", name, mounts[0])
+	}
+	if volume.Driver != common.DefaultVolumeDriver {
+		driver, err := getVolumeDriver(volume.Driver)
+		if err != nil {
+			return err
+		}
+		if err = driver.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(volumeDir)
+}
+
+// 从驱动获取命名数据卷在宿主机上的路径，id 为使用该卷的容器
+func mountNamedVolume(volume *Volume, id string) error {
+	config, err := createVolume(volume.Name, volume.Driver, nil, nil)
+	if err != nil {
+		return err
+	}
+	driver, err := getVolumeDriver(config.Driver)
+	if err != nil {
+		return err
+	}
+	mountpoint, err := driver.Mount(volume.Name, id)
+	if err != nil {
+		return err
+	}
+	volume.Driver = config.Driver
+	volume.Source = mountpoint
+
+	return nil
+}
+
+// 通知驱动容器 id 不再使用这些数据卷
+func releaseVolumes(volumes []*Volume, id string) {
+	for _, volume := range volumes {
+		if volume.Name == "" || volume.Driver == "" || volume.Driver == common.DefaultVolumeDriver {
+			continue
+		}
+		driver, err := getVolumeDriver(volume.Driver)
+		if err == nil {
+			err = driver.Unmount(volume.Name, id)
+		}
+		if err != nil {
+			logrus.Errorf("unmount volume %s, err: %v", volume.Name, err)
+		}
+	}
+}
+
+// 统计每个命名数据卷被哪些容器引用
+func volumeRefs(infos []*ContainerInfo) map[string][]string {
+	refs := make(map[string][]string)
+	for _, info := range infos {
+		for _, volume := range info.volumes() {
+			if volume.Name != "" {
+				refs[volume.Name] = append(refs[volume.Name], info.Name)
+			}
+		}
+	}
+	for _, containers := range refs {
+		sort.Strings(containers)
+	}
+
+	return refs
+}
+
+// 数据卷还被容器引用时不能删除，refs 由 volumeRefs 统计
+func volumeUnused(name string, refs map[string][]string) error {
+	if containers := refs[name]; len(containers) > 0 {
+		return fmt.Errorf("volume %s is in use by container(s) %s", name, strings.Join(containers, ", "))
+	}
+
+	return nil
+}
+
+// 命名数据卷第一次被使用时用镜像中的内容初始化，与 docker 的行为一致
+// 之后即使用户清空了卷也不再拷贝
+func seedNamedVolume(rootPath string, volume *Volume) error {
+	config, err := getVolume(volume.Name)
+	if err != nil {
+		return err
+	}
+	if config.Seeded {
+		return nil
+	}
+	if err = seedVolume(rootPath, volume); err != nil {
+		return err
+	}
+	config.Seeded = true
+
+	return saveVolume(config)
+}
+
+// 数据卷为空时，将容器根目录 rootPath 中挂载点下原有的内容拷贝到卷中
+// 必须在卷挂载之前调用，此时挂载点下看到的是镜像中的内容
+func seedVolume(rootPath string, volume *Volume) error {
+	files, err := ioutil.ReadDir(volume.Source)
+	if err != nil || len(files) > 0 {
+		return err
+	}
+	rootFS := &layerFS{layers: []string{rootPath}}
+	rel, err := rootFS.resolve(relPath(volume.Destination), true)
+	if err != nil {
+		return err
+	}
+	_, fi, err := rootFS.lstat(rel)
+	if err != nil || !fi.IsDir() {
+		// 镜像中没有该目录，不需要拷贝
+		return nil
+	}
+	names, err := rootFS.readDir(rel)
+	if err != nil {
+		return err
+	}
+	dataFS := &layerFS{layers: []string{volume.Source}}
+	for _, name := range names {
+		if err = copyPath(rootFS, path.Join(rel, name), false, dataFS, ""); err != nil {
+			return err
+		}
+	}
+	// 数据目录的权限和属主与镜像中的目录保持一致
+	if err = os.Chmod(volume.Source, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		_ = os.Lchown(volume.Source, int(stat.Uid), int(stat.Gid))
+	}
+
+	return nil
+}
+
+func volumeConfigPath(name string) string {
+	return path.Join(common.DefaultVolumePath, name, common.VolumeConfigFileName)
+}
+
+// 解析 key=value 列表
+func parseKeyValues(values []string) map[string]string {
+	result := make(map[string]string)
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		} else {
+			result[kv[0]] = ""
+		}
+	}
+
+	return result
+}
+
+// 随机生成的卷名
+func randomVolumeName() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,154 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected *Volume
+	}{
+		{"/data:/app", &Volume{Source: "/data", Destination: "/app", Propagation: PropagationRPrivate, Recursive: true}},
+		{"/data/../srv/:/app/./conf/", &Volume{Source: "/srv", Destination: "/app/conf", Propagation: PropagationRPrivate, Recursive: true}},
+		{"/data:/app:ro", &Volume{Source: "/data", Destination: "/app", ReadOnly: true, Propagation: PropagationRPrivate, Recursive: true}},
+		{"/data:/app:ro,rw", &Volume{Source: "/data", Destination: "/app", Propagation: PropagationRPrivate, Recursive: true}},
+		{"/data:/app:rw,bind,rslave", &Volume{Source: "/data", Destination: "/app", Propagation: PropagationRSlave}},
+		{"/data:/app:shared,ro", &Volume{Source: "/data", Destination: "/app", ReadOnly: true, Propagation: PropagationShared, Recursive: true}},
+		{"/data:/app:bind,rbind,private", &Volume{Source: "/data", Destination: "/app", Propagation: PropagationPrivate, Recursive: true}},
+		{"cache:/cache", &Volume{Name: "cache", Source: namedVolumePath("cache"), Destination: "/cache", Propagation: PropagationRPrivate, Recursive: true}},
+		{"my.vol-1_x:/v:ro", &Volume{Name: "my.vol-1_x", Source: namedVolumePath("my.vol-1_x"), Destination: "/v", ReadOnly: true, Propagation: PropagationRPrivate, Recursive: true}},
+	}
+	for _, test := range tests {
+		volume, err := ParseVolume(test.spec)
+		if err != nil {
+			t.Errorf("ParseVolume(%q), err: %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(volume, test.expected) {
+			t.Errorf("ParseVolume(%q) = %+v, expected %+v", test.spec, volume, test.expected)
+		}
+	}
+
+	invalid := []string{
+		"/data",
+		"/data:/app:ro:extra",
+		"/data:app",
+		"/data:./app",
+		"/data:/",
+		"/data:/app/..",
+		"/data:/app:",
+		"/data:/app:rx",
+		"/data:/app:ro,,rw",
+		"data/sub:/app",
+		"./data:/app",
+		"-vol:/app",
+		"vol@1:/app",
+		":/app",
+	}
+	for _, spec := range invalid {
+		if volume, err := ParseVolume(spec); err == nil {
+			t.Errorf("ParseVolume(%q) = %+v, expected error", spec, volume)
+		}
+	}
+}
+
+func TestParseVolumes(t *testing.T) {
+	volumes, err := ParseVolumes([]string{"/data:/app", "cache:/cache:ro"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 || volumes[0].Destination != "/app" || volumes[1].Name != "cache" {
+		t.Errorf("ParseVolumes = %v", volumes)
+	}
+	if volumes, err = ParseVolumes(nil); err != nil || len(volumes) != 0 {
+		t.Errorf("ParseVolumes(nil) = %v, %v, expected no volumes", volumes, err)
+	}
+
+	invalid := [][]string{
+		{"/data:/app", "cache:/app"},
+		{"/data:/app", "/other:/app/"},
+		{"/data:/app", "/other:/srv/../app:ro"},
+		{"/data:/app", "bad:spec:x"},
+	}
+	for _, specs := range invalid {
+		if _, err := ParseVolumes(specs); err == nil {
+			t.Errorf("ParseVolumes(%q) should fail", specs)
+		}
+	}
+}
+
+func TestVolumeString(t *testing.T) {
+	for _, spec := range []string{"/data:/app:ro,rslave,bind", "cache:/cache:rw,rprivate,rbind"} {
+		volume, err := ParseVolume(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := volume.String(); s != spec {
+			t.Errorf("ParseVolume(%q).String() = %q", spec, s)
+		}
+	}
+}
+
+func TestVolumeRefs(t *testing.T) {
+	infos := []*ContainerInfo{
+		{Name: "web", Config: &InitConfig{Volumes: []*Volume{{Name: "data"}, {Source: "/host"}}}},
+		{Name: "db", Config: &InitConfig{Volumes: []*Volume{{Name: "data"}, {Name: "logs"}}}},
+		{Name: "idle"},
+	}
+	refs := volumeRefs(infos)
+	expected := map[string][]string{"data": {"db", "web"}, "logs": {"db"}}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("volumeRefs = %v, expected %v", refs, expected)
+	}
+
+	if err := volumeUnused("data", refs); err == nil {
+		t.Errorf("volume data is in use by db and web, removal should be refused")
+	}
+	if err := volumeUnused("unused", refs); err != nil {
+		t.Errorf("volume unused is not referenced, err: %v", err)
+	}
+}
+
+func TestSeedVolume(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "var", "lib", "app", "conf"), 0755)
+	_ = os.Chmod(filepath.Join(root, "var", "lib", "app"), 0750)
+	_ = ioutil.WriteFile(filepath.Join(root, "var", "lib", "app", "conf", "app.ini"), []byte("ini"), 0644)
+	_ = os.Symlink("/var/lib/app", filepath.Join(root, "app"))
+
+	// 空的卷拷贝镜像中的内容，软链接在容器根目录内解析
+	empty := &Volume{Name: "empty", Source: t.TempDir(), Destination: "/app"}
+	if err := seedVolume(root, empty); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(empty.Source, "conf", "app.ini")); string(bs) != "ini" {
+		t.Errorf("seeded app.ini = %q, expected ini", bs)
+	}
+	if fi, err := os.Stat(empty.Source); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("seeded volume = %v, %v, expected the mode of /var/lib/app", fi, err)
+	}
+
+	// 已有内容的卷不能被覆盖
+	used := &Volume{Name: "used", Source: t.TempDir(), Destination: "/var/lib/app"}
+	_ = ioutil.WriteFile(filepath.Join(used.Source, "existing"), []byte("data"), 0600)
+	if err := seedVolume(root, used); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, used.Source); !reflect.DeepEqual(files, []string{"existing"}) {
+		t.Errorf("non-empty volume files = %v, expected [existing]", files)
+	}
+
+	// 镜像中没有挂载点目录时不拷贝
+	missing := &Volume{Name: "missing", Source: t.TempDir(), Destination: "/missing"}
+	if err := seedVolume(root, missing); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, missing.Source); len(files) != 0 {
+		t.Errorf("volume without image dir files = %v, expected none", files)
+	}
+}
@@ -10,14 +10,15 @@ import (
 	"docker-go/common"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
-	"strings"
 )
 
 // NewWorkSpace 创建容器运行时目录
-func NewWorkSpace(volume, containerName, imageNmae string) error {
+// storageSize 大于 0 时限制读写层的大小
+func NewWorkSpace(volumes []*Volume, containerName, imageNmae string, storageSize int64) error {
 	// 1.创建只读层
 	err := createReadOnlyLayer(imageNmae)
 	if err != nil {
@@ -25,7 +26,7 @@ func NewWorkSpace(volume, containerName, imageNmae string) error {
 		return err
 	}
 	// 2. 创建读写层
-	err = createWriteLayer(containerName)
+	err = createWriteLayer(containerName, storageSize)
 	if err != nil {
 		logrus.Errorf("create write layer, err: %v", err)
 		return err
@@ -37,37 +38,131 @@ func NewWorkSpace(volume, containerName, imageNmae string) error {
 		return err
 	}
 
-	// 4. 设置宿主机与容器文件映射
-	mountVolume(containerName, imageNmae, volume)
+	// 4. 准备宿主机与容器文件映射
+	err = prepareVolumes(containerName, volumes)
+	if err != nil {
+		logrus.Errorf("prepare volumes, err: %v", err)
+		return err
+	}
 
 	return nil
 }
 
 // 根据镜像创建只读层
+// 镜像先解压到新的目录中，解压时校验各层的摘要，再替换 /root/镜像名
+// 解压来源的摘要记录在镜像存储中，镜像内容变化后重新解压，不会与旧的内容混在一起
 func createReadOnlyLayer(imageName string) error {
-	// 创建只读层目录
+	sources, digest, err := imageLayerSources(imageName)
+	if err != nil {
+		logrus.Errorf("get image layers, image: %s, err: %v", imageName, err)
+		return err
+	}
 	imagePath := path.Join(common.RootPath, imageName)
-	_, err := os.Stat(imagePath)
-	if err != nil && os.IsNotExist(err) {
-		err := os.MkdirAll(imagePath, os.ModePerm)
-		if err != nil {
-			logrus.Errorf("mkdir image path, err: %v", err)
-			return err
+	if files, _ := ioutil.ReadDir(imagePath); len(files) > 0 && loadRootfsDigest(imageName) == digest {
+		return nil
+	}
+
+	tmpPath, err := ioutil.TempDir(common.RootPath, fmt.Sprintf(".%s-", imageName))
+	if err != nil {
+		logrus.Errorf("mkdir image tmp path, err: %v", err)
+		return err
+	}
+	if err = applyImageLayers(tmpPath, sources); err != nil {
+		_ = os.RemoveAll(tmpPath)
+		return err
+	}
+	if err = os.Chmod(tmpPath, 0755); err != nil {
+		_ = os.RemoveAll(tmpPath)
+		return err
+	}
+	if err = os.RemoveAll(imagePath); err != nil {
+		_ = os.RemoveAll(tmpPath)
+		logrus.Errorf("remove stale image path: %s, err: %v", imagePath, err)
+		return err
+	}
+	if err = os.Rename(tmpPath, imagePath); err != nil {
+		_ = os.RemoveAll(tmpPath)
+		logrus.Errorf("rename image path: %s, err: %v", imagePath, err)
+		return err
+	}
+
+	return saveRootfsDigest(imageName, digest)
+}
+
+// 获取解压镜像需要的各层文件，以及标识这些内容的摘要
+// 信任策略要求签名时只使用通过校验的清单，没有清单或由 tar 包导入的镜像以 tar 包当前的内容为准
+func imageLayerSources(imageName string) ([]*layerSource, string, error) {
+	manifest, bs, err := trustedManifest(imageName)
+	if err != nil {
+		return nil, "", err
+	}
+	if manifest == nil {
+		manifest, bs, err = loadImageManifest(imageName)
+		if err != nil || manifest.legacy() {
+			digest, _, err := fileDigest(imageTarPath(imageName))
+			if err != nil {
+				return nil, "", err
+			}
+			return []*layerSource{{blobPath: imageTarPath(imageName), digest: digest}}, digest, nil
 		}
 	}
 
-	// 解压 /root/imageName.tar
-	imageTarPath := path.Join(common.RootPath, fmt.Sprintf("%s.tar", imageName))
-	if _, err = exec.Command("tar", "-xvf", imageTarPath, "-C", imagePath).CombinedOutput(); err != nil {
-		logrus.Errorf("tar image tar, path: %s, err: %v", imageTarPath, err)
+	var sources []*layerSource
+	for _, layer := range manifest.Layers {
+		sources = append(sources, &layerSource{blobPath: layerBlobPath(manifest, layer), digest: layer.Digest})
+	}
+
+	return sources, manifestDigest(bs), nil
+}
+
+// 读取 /root/镜像名 解压来源的摘要
+func loadRootfsDigest(imageName string) string {
+	bs, err := ioutil.ReadFile(path.Join(common.ImageStorePath, imageName, common.ImageRootfsDigestName))
+	if err != nil {
+		return ""
+	}
+
+	return string(bs)
+}
+
+// 记录 /root/镜像名 解压来源的摘要
+func saveRootfsDigest(imageName, digest string) error {
+	dir := path.Join(common.ImageStorePath, imageName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logrus.Errorf("mkdir image store dir: %s, err: %v", dir, err)
+		return err
+	}
+	digestPath := path.Join(dir, common.ImageRootfsDigestName)
+	if err := ioutil.WriteFile(digestPath, []byte(digest), 0644); err != nil {
+		logrus.Errorf("write rootfs digest, path: %s, err: %v", digestPath, err)
 		return err
 	}
 
 	return nil
 }
 
+// 获取所有由镜像解压出来的目录
+func listImageDirs() []os.FileInfo {
+	files, err := ioutil.ReadDir(common.RootPath)
+	if err != nil {
+		logrus.Errorf("read image dir, err: %v", err)
+		return nil
+	}
+	var images []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		if imageExists(file.Name()) {
+			images = append(images, file)
+		}
+	}
+
+	return images
+}
+
 // 创建读写层
-func createWriteLayer(containerName string) error {
+func createWriteLayer(containerName string, storageSize int64) error {
 	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
 	_, err := os.Stat(writeLayerPath)
 	if err != nil && os.IsNotExist(err) {
@@ -77,6 +172,14 @@ func createWriteLayer(containerName string) error {
 			return err
 		}
 	}
+	if storageSize > 0 {
+		quota, err := setWriteLayerQuota(containerName, storageSize)
+		if err != nil {
+			logrus.Errorf("set write layer quota, err: %v", err)
+			return err
+		}
+		logrus.Infof("write layer of %s limited to %s by %s quota", containerName, humanSize(storageSize), quota)
+	}
 
 	return nil
 }
@@ -105,63 +208,54 @@ func CreateMountPoint(containerName, imageName string) error {
 	return nil
 }
 
-// 创建挂载卷
-func mountVolume(containerName, imageName, volume string) {
-	if volume != "" {
-		volumes := strings.Split(volume, ":")
-		if len(volumes) > 1 {
-			// 创建宿主机中文件路径
-			parentPath := volumes[0]
-			if _, err := os.Stat(parentPath); err != nil && os.IsNotExist(err) {
-				if err = os.MkdirAll(parentPath, os.ModePerm); err != nil {
-					logrus.Errorf("mkdir parent path: %s, err: %v", parentPath, err)
-				}
+// 准备数据卷，宿主机路径不存在时创建，命名数据卷不存在时自动创建，并从驱动获取挂载路径
+// 数据卷由容器的 init 进程在 mount namespace 中以 bind mount 方式挂载
+func prepareVolumes(containerName string, volumes []*Volume) error {
+	for i, volume := range volumes {
+		if volume.Name != "" {
+			if err := mountNamedVolume(volume, containerName); err != nil {
+				logrus.Errorf("mount volume %s, err: %v", volume.Name, err)
+				releaseVolumes(volumes[:i], containerName)
+				return err
 			}
-
-			// 创建容器内挂载点
-			containerPath := volumes[1]
-			containerVolumePath := path.Join(common.MntPath, containerName, containerPath)
-			if _, err := os.Stat(containerVolumePath); err != nil && os.IsNotExist(err) {
-				if err = os.MkdirAll(containerVolumePath, os.ModePerm); err != nil {
-					logrus.Errorf("mkdir volume path path: %s, err: %v", containerVolumePath, err)
-				}
+			// 第一次使用的空的命名数据卷使用镜像中挂载点下的内容初始化
+			if err := seedNamedVolume(path.Join(common.MntPath, containerName), volume); err != nil {
+				logrus.Errorf("seed volume %s, err: %v", volume.Name, err)
+				releaseVolumes(volumes[:i+1], containerName)
+				return err
 			}
-
-			// 把宿主机文件目录挂载到容器挂载点中
-			dirs := fmt.Sprintf("dirs=%s", parentPath)
-			cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumePath)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				logrus.Errorf("mount cmd run, err: %v", err)
+			continue
+		}
+		if _, err := os.Stat(volume.Source); err != nil && os.IsNotExist(err) {
+			if err = os.MkdirAll(volume.Source, os.ModePerm); err != nil {
+				logrus.Errorf("mkdir parent path: %s, err: %v", volume.Source, err)
+				return err
 			}
 		}
 	}
+
+	return nil
 }
 
 // DeleteWorkSpace 删除容器工作空间
-func DeleteWorkSpace(containerName, volume string) error {
-	// 1. 卸载挂载点
-	err := unMountPoint(containerName)
-	if err != nil {
-		return err
-	}
+func DeleteWorkSpace(containerName string, volumes []*Volume) error {
+	// 1. 通知数据卷驱动容器不再使用数据卷
+	releaseVolumes(volumes, containerName)
 
-	// 2. 删除读写层
-	err = deleteWriteLayer(containerName)
+	// 2. 卸载挂载点
+	err := unMountPoint(containerName)
 	if err != nil {
 		return err
 	}
 
-	// 3. 删除宿主机与文件系统映射
-	deleteVolume(containerName, volume)
-	return nil
+	// 3. 删除读写层
+	return deleteWriteLayer(containerName)
 }
 
-// 卸载挂载点
+// 卸载挂载点，数据卷只挂载在容器的 mount namespace 中，随容器退出自动卸载
 func unMountPoint(containerName string) error {
 	mntPath := path.Join(common.MntPath, containerName)
-	if _, err := exec.Command("umount", mntPath).CombinedOutput(); err != nil {
+	if err := unmountAll(mntPath); err != nil {
 		logrus.Errorf("unmount mnt, err: %v", err)
 		return err
 	}
@@ -174,22 +268,12 @@ func unMountPoint(containerName string) error {
 	return nil
 }
 
-// 删除读写层
+// 删除读写层，读写层为 loop 设备时先卸载
 func deleteWriteLayer(containerName string) error {
 	wirteLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
-	return os.RemoveAll(wirteLayerPath)
-}
-
-// 删除文件系统映射
-func deleteVolume(containerName, volume string) {
-	if volume != "" {
-		volumes := strings.Split(volume, ":")
-		if len(volumes) > 1 {
-			mntPath := path.Join(common.MntPath, common.WriteLayer, containerName)
-			containerPath := path.Join(mntPath, volumes[1])
-			if _, err := exec.Command("umount", containerPath).CombinedOutput(); err != nil {
-				logrus.Errorf("umount container path, err: %v", err)
-			}
-		}
+	if err := removeLoopback(wirteLayerPath); err != nil {
+		logrus.Errorf("remove write layer loopback, err: %v", err)
+		return err
 	}
+	return os.RemoveAll(wirteLayerPath)
 }
@@ -26,8 +26,21 @@ func main() {
 		listCommand,
 		logCommand,
 		execCommand,
+		attachCommand,
+		shimCommand,
+		inspectCommand,
 		stopCommand,
+		killCommand,
+		startCommand,
+		restartCommand,
+		waitCommand,
 		removeCommand,
+		diffCommand,
+		copyCommand,
+		systemCommand,
+		imageCommand,
+		containerCommand,
+		volumeCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
@@ -11,60 +11,87 @@ import (
 	"docker-go/cgroups"
 	"docker-go/cgroups/subsystem"
 	"docker-go/container"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
-	"strings"
 )
 
-func Run(cmdArray []string, tty bool, res *subsystem.ResourceConfig, containerName, imageName, volume, net string, envs, ports []string) {
+// 容器没有成功启动时返回错误，run 命令以非 0 状态退出
+func Run(config *container.InitConfig, detach bool, res *subsystem.ResourceConfig, containerName, imageName string, storageSize int64, stopSignal, net string, ports []string) error {
 	containerID := container.GetContainerID(10)
 	if containerName == "" {
 		containerName = containerID
 	}
-	parent, writePipe := container.NewParentProcess(tty, volume, containerName, imageName, envs)
-	if parent == nil {
-		logrus.Errorf("failed to new parent process")
-		return
+	// 默认的主机名为容器 ID
+	if config.Hostname == "" {
+		config.Hostname = containerID
 	}
-	if err := parent.Start(); err != nil {
-		logrus.Errorf("parent start failed, err %v", err)
-		return
+	if config.Tty && !detach && !container.IsTerminal(os.Stdin) {
+		return fmt.Errorf("the input device is not a TTY")
 	}
-	// 记录容器信息
-	err := container.RecordContainerInfo(parent.Process.Pid, cmdArray, containerName, containerID)
+	// 创建工作空间，命名数据卷的宿主机路径在这一步确定
+	// 解压镜像时按照信任策略校验镜像签名，不满足策略的镜像不能运行
+	if err := container.NewWorkSpace(config.Volumes, containerName, imageName, storageSize); err != nil {
+		logrus.Errorf("new work space, err: %v", err)
+		return err
+	}
+	// 记录容器信息，后台运行的容器由 shim 按照记录的配置启动
+	err := container.RecordContainerInfo(containerName, containerID, imageName, config, res, storageSize, stopSignal)
 	if err != nil {
 		logrus.Errorf("record container info, err: %v", err)
+		return err
+	}
+	if !config.Tty || detach {
+		if err = container.StartShim(containerName); err != nil {
+			logrus.Errorf("start shim, err: %v", err)
+			// 容器没有启动，删除工作空间和容器信息
+			if deleteErr := container.DeleteWorkSpace(containerName, config.Volumes); deleteErr != nil {
+				logrus.Errorf("delete work space, err: %v", deleteErr)
+			}
+			container.DeleteContainerInfo(containerName)
+		}
+		return err
 	}
+
+	// 前台运行时由当前进程等待容器退出
 	// 添加资源限制
-	cgroupManager := cgroups.NewCGroupManager("docker-go")
+	cgroupManager := cgroups.NewCGroupManager(container.CgroupPath(containerName))
 	// 删除资源限制
 	defer cgroupManager.Destroy()
-	// 设置资源限制
-	cgroupManager.Set(res)
-	// 将容器进程，加入到各个subsystem挂载对应的cgroup中
-	cgroupManager.Apply(parent.Process.Pid)
-	// 设置初始化命令
-	sendInitCommand(cmdArray, writePipe)
+	parent, containerIO, err := container.LaunchContainer(config, res, cgroupManager, containerName, func(pid int) error {
+		return container.SetContainerRunning(containerName, pid, 0)
+	})
+	if err != nil {
+		logrus.Errorf("launch container, err: %v", err)
+		return err
+	}
+	// 接收容器的终端，与用户的终端互相转发
+	wait := func() {}
+	master, err := container.ReceiveConsole(containerIO.Console)
+	if err != nil {
+		logrus.Errorf("receive console, err: %v", err)
+	} else {
+		wait = container.ProxyConsole(master)
+	}
 	// 等待父进程结束
-	if tty {
-		// 等待父进程结束
-		err := parent.Wait()
-		if err != nil {
-			logrus.Errorf("parent wait, err: %v", err)
-		}
-		// 删除容器工作空间
-		err = container.DeleteWorkSpace(containerName, volume)
-		if err != nil {
-			logrus.Errorf("delete work space, err: %v", err)
+	err = parent.Wait()
+	wait()
+	if err != nil {
+		logrus.Errorf("parent wait, err: %v", err)
+	}
+	// 记录退出状态，cgroup 删除之前才能检查是否被 OOM killer 杀死
+	if parent.ProcessState != nil {
+		if err = container.RecordContainerExit(containerName, parent.ProcessState); err != nil {
+			logrus.Errorf("record container exit, err: %v", err)
 		}
-		// 删除容器信息
-		container.DeleteContainerInfo(containerName)
 	}
-}
+	// 删除容器工作空间
+	err = container.DeleteWorkSpace(containerName, config.Volumes)
+	if err != nil {
+		logrus.Errorf("delete work space, err: %v", err)
+	}
+	// 删除容器信息
+	container.DeleteContainerInfo(containerName)
 
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	logrus.Infof("command all is %s", command)
-	_, _ = writePipe.WriteString(command)
-	_ = writePipe.Close()
+	return nil
 }
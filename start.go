@@ -0,0 +1,27 @@
+/*
+	start 按照容器信息中记录的配置重新启动已经停止的容器，restart 先停止再启动
+	重新启动的容器保留原来的读写层，由 shim 在后台运行
+*/
+
+package main
+
+import (
+	"docker-go/container"
+	"time"
+)
+
+func Start(containerName string) error {
+	if _, err := container.PrepareStart(containerName); err != nil {
+		return err
+	}
+
+	return container.StartShim(containerName)
+}
+
+func Restart(containerName string, timeout time.Duration) error {
+	if err := container.StopContainer(containerName, timeout); err != nil {
+		return err
+	}
+
+	return Start(containerName)
+}